@@ -0,0 +1,322 @@
+package zip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/.
+const (
+	winZipAES         = 99 // compression method of the encrypted files
+	aesExtraLen       = 11 // extra block of the encrypted files
+	aesVerifierLen    = 2
+	aesMACLen         = 10
+	aesKeyIterations  = 1000
+	aesVendorVersion1 = 1      // AE-1, the CRC-32 checksum is stored
+	aesVendorVersion2 = 2      // AE-2, the CRC-32 checksum is zero
+	aesVendorID       = 0x4541 // "AE"
+)
+
+// EncryptionMethod is the encryption method of a file, see
+// [FileHeader.SetPassword].
+type EncryptionMethod uint8
+
+// Encryption methods. The WinZip AES encryption is the AE-2 format, which
+// stores no CRC-32 checksum of the file content, and the content is
+// authenticated by HMAC-SHA1 instead.
+const (
+	AES128 EncryptionMethod = 1 // WinZip AES encryption with a 128-bit key
+	AES192 EncryptionMethod = 2 // WinZip AES encryption with a 192-bit key
+	AES256 EncryptionMethod = 3 // WinZip AES encryption with a 256-bit key
+)
+
+// keyLen returns the length of the AES key in bytes.
+func (e EncryptionMethod) keyLen() int {
+	return 8 + 8*int(e)
+}
+
+// saltLen returns the length of the salt in bytes.
+func (e EncryptionMethod) saltLen() int {
+	return 4 + 4*int(e)
+}
+
+func (e EncryptionMethod) isAES() bool {
+	return e >= AES128 && e <= AES256
+}
+
+// SetPassword makes [Writer.CreateHeader] and [Updater.AppendHeader] encrypt
+// the content of the file with the password using the encryption method enc.
+// For the WinZip AES encryption, the compression method of the file is stored
+// in the extra field of the encryption, and the Method of h is set to 99 when
+// the file is written. Directories are not encrypted.
+//
+// To read an encrypted file, use [File.OpenWithPassword] or
+// [Reader.SetPasswordFunc].
+func (h *FileHeader) SetPassword(password string, enc EncryptionMethod) {
+	h.password = password
+	h.encryption = enc
+}
+
+// IsEncrypted reports whether the content of the file is encrypted.
+func (h *FileHeader) IsEncrypted() bool {
+	return h.Flags&0x1 != 0
+}
+
+// aesExtra returns the vendor version, the key strength and the compression
+// method stored in the extra block of the WinZip AES encryption.
+func (h *FileHeader) aesExtra() (version uint16, enc EncryptionMethod, method uint16, ok bool) {
+	b := readBuf(h.Extra)
+	for len(b) >= 4 { // need at least tag and size
+		tag := b.uint16()
+		size := int(b.uint16())
+		if len(b) < size {
+			break
+		}
+		data := b.sub(size)
+		if tag != winZipAESExtraID || size < 7 {
+			continue
+		}
+		version = data.uint16()
+		vendor := data.uint16()
+		enc = EncryptionMethod(data.uint8())
+		method = data.uint16()
+		ok = vendor == aesVendorID && enc.isAES()
+		return version, enc, method, ok
+	}
+	return 0, 0, 0, false
+}
+
+// encryptCompressor returns a compressor which encrypts the data compressed
+// by comp, and sets the fields of fh for the encryption, if the password of
+// fh is set by FileHeader.SetPassword.
+func encryptCompressor(fh *FileHeader, comp Compressor) (Compressor, error) {
+	password, enc := fh.password, fh.encryption
+	var encrypt func(w io.Writer) (io.WriteCloser, error)
+	switch {
+	case enc == 0:
+		return comp, nil
+	case enc.isAES():
+		var buf [aesExtraLen]byte
+		eb := writeBuf(buf[:])
+		eb.uint16(winZipAESExtraID)
+		eb.uint16(aesExtraLen - 4)
+		eb.uint16(aesVendorVersion2)
+		eb.uint16(aesVendorID)
+		eb.uint8(uint8(enc))
+		eb.uint16(fh.Method)
+		fh.Extra = append(stripExtra(fh.Extra, winZipAESExtraID), buf[:]...)
+		fh.Method = winZipAES
+		fh.ReaderVersion = max(fh.ReaderVersion, zipVersion51)
+		encrypt = func(w io.Writer) (io.WriteCloser, error) {
+			return newAESWriter(w, password, enc)
+		}
+	case enc == InsecureZipCrypto:
+		check := fh.zipCryptoCheck()
+		encrypt = func(w io.Writer) (io.WriteCloser, error) {
+			return newZipCryptoWriter(w, password, check)
+		}
+	default:
+		return nil, errors.New("zip: unsupported encryption method")
+	}
+	fh.Flags |= 0x1
+
+	return func(w io.Writer) (io.WriteCloser, error) {
+		ew, err := encrypt(w)
+		if err != nil {
+			return nil, err
+		}
+		cw, err := comp(ew)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptWriter{WriteCloser: cw, enc: ew}, nil
+	}, nil
+}
+
+// encryptionOverhead returns the length of the extra block and the data added
+// to a file by the encryption method.
+func encryptionOverhead(enc EncryptionMethod) (extraLen, dataLen int) {
+	switch {
+	case enc.isAES():
+		return aesExtraLen, enc.saltLen() + aesVerifierLen + aesMACLen
+	case enc == InsecureZipCrypto:
+		return 0, zipCryptoHeaderLen
+	}
+	return 0, 0
+}
+
+// encryptWriter closes the encryption after the compressor.
+type encryptWriter struct {
+	io.WriteCloser
+	enc io.WriteCloser
+}
+
+func (w *encryptWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.enc.Close()
+}
+
+// aesKeys derives the encryption key, the authentication key and the password
+// verifier from the password and the salt.
+func aesKeys(password string, salt []byte, enc EncryptionMethod) (key, macKey, verifier []byte) {
+	n := enc.keyLen()
+	dk := pbkdf2.Key([]byte(password), salt, aesKeyIterations, 2*n+aesVerifierLen, sha1.New)
+	return dk[:n], dk[n : 2*n], dk[2*n:]
+}
+
+// aesCTR is the AES counter mode used by WinZip, whose counter is a
+// little-endian integer starting at 1.
+type aesCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newAESCTR(key []byte) (*aesCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesCTR{block: block, pos: aes.BlockSize}, nil
+}
+
+func (c *aesCTR) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if c.pos == aes.BlockSize {
+			for i := range c.counter {
+				c.counter[i]++
+				if c.counter[i] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		n := subtle.XORBytes(dst, src, c.stream[c.pos:])
+		c.pos += n
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// aesWriter encrypts the data written to w. The salt and the password
+// verifier are written before the data, and the authentication code is
+// written by Close.
+type aesWriter struct {
+	w   io.Writer
+	ctr *aesCTR
+	mac hash.Hash
+	buf []byte
+}
+
+// newAESWriter returns a writer encrypting the data written to w, and writes
+// the salt and the password verifier.
+func newAESWriter(w io.Writer, password string, enc EncryptionMethod) (*aesWriter, error) {
+	salt := make([]byte, enc.saltLen())
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, macKey, verifier := aesKeys(password, salt, enc)
+	ctr, err := newAESCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(verifier); err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, ctr: ctr, mac: hmac.New(sha1.New, macKey), buf: make([]byte, 4096)}, nil
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), len(w.buf))
+		w.ctr.XORKeyStream(w.buf[:n], p[:n])
+		w.mac.Write(w.buf[:n])
+		if _, err := w.w.Write(w.buf[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *aesWriter) Close() error {
+	_, err := w.w.Write(w.mac.Sum(nil)[:aesMACLen])
+	return err
+}
+
+// aesReader decrypts the file data encrypted by the WinZip AES encryption.
+type aesReader struct {
+	data *io.SectionReader
+	code *io.SectionReader
+	ctr  *aesCTR
+	mac  hash.Hash
+}
+
+// newAESReader returns a reader of the decrypted content of the file data r.
+// It returns ErrPassword if the password verifier does not match.
+func newAESReader(r *io.SectionReader, enc EncryptionMethod, password string) (*aesReader, error) {
+	saltLen := int64(enc.saltLen())
+	size := r.Size() - saltLen - aesVerifierLen - aesMACLen
+	if size < 0 {
+		return nil, ErrFormat
+	}
+	buf := make([]byte, saltLen+aesVerifierLen)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	key, macKey, verifier := aesKeys(password, buf[:saltLen], enc)
+	if subtle.ConstantTimeCompare(verifier, buf[saltLen:]) != 1 {
+		return nil, ErrPassword
+	}
+	ctr, err := newAESCTR(key)
+	if err != nil {
+		return nil, err
+	}
+	offset := saltLen + aesVerifierLen
+	return &aesReader{
+		data: io.NewSectionReader(r, offset, size),
+		code: io.NewSectionReader(r, offset+size, aesMACLen),
+		ctr:  ctr,
+		mac:  hmac.New(sha1.New, macKey),
+	}, nil
+}
+
+func (r *aesReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	r.mac.Write(p[:n])
+	r.ctr.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// verify checks the authentication code of the file data. The data not read
+// by the decompressor is authenticated as well.
+func (r *aesReader) verify() error {
+	if _, err := io.Copy(r.mac, r.data); err != nil {
+		return err
+	}
+	var code [aesMACLen]byte
+	if _, err := io.ReadFull(r.code, code[:]); err != nil {
+		return err
+	}
+	if !hmac.Equal(code[:], r.mac.Sum(nil)[:aesMACLen]) {
+		return ErrChecksum
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReaderWinZipAES(t *testing.T) {
+	// Created by bsdtar --options zip:encryption=aes256 --passphrase go-zip.
+	f, err := os.Open("testdata/winzip-aes256.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"short.txt": "short\n",
+		"long.txt":  strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40) + "\n",
+	}
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for _, zf := range r.File {
+		if !zf.IsEncrypted() {
+			t.Errorf("file %q is not encrypted", zf.Name)
+		}
+		if _, err := zf.Open(); !errors.Is(err, ErrPassword) {
+			t.Errorf("file %q: Open without password: got error %v, want %v", zf.Name, err, ErrPassword)
+		}
+		if _, err := zf.OpenWithPassword("wrong"); !errors.Is(err, ErrPassword) {
+			t.Errorf("file %q: got error %v, want %v", zf.Name, err, ErrPassword)
+		}
+		testOpenWithPassword(t, zf, "go-zip", want[zf.Name])
+	}
+
+	r.SetPasswordFunc(func(f *File) (string, error) {
+		return "go-zip", nil
+	})
+	b, err := fs.ReadFile(r, "short.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != want["short.txt"] {
+		t.Errorf("got %q, want %q", b, want["short.txt"])
+	}
+}
+
+func testOpenWithPassword(t *testing.T, f *File, password, want string) {
+	t.Helper()
+	rc, err := f.OpenWithPassword(password)
+	if err != nil {
+		t.Fatalf("file %q: %v", f.Name, err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("file %q: %v", f.Name, err)
+	}
+	if string(b) != want {
+		t.Errorf("file %q: got %q, want %q", f.Name, b, want)
+	}
+}
+
+func TestWriterWinZipAES(t *testing.T) {
+	data := strings.Repeat("encrypted data ", 100)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, enc := range []EncryptionMethod{AES128, AES192, AES256} {
+		for _, method := range []uint16{Store, Deflate} {
+			fh := &FileHeader{Name: fmt.Sprintf("aes%d-%d", enc.keyLen()*8, method), Method: method}
+			fh.SetPassword("password", enc)
+			fw, err := w.CreateHeader(fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(fw, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	empty := &FileHeader{Name: "empty"}
+	empty.SetPassword("password", AES256)
+	if _, err := w.CreateHeader(empty); err != nil {
+		t.Fatal(err)
+	}
+	dir := &FileHeader{Name: "dir/"}
+	dir.SetPassword("password", AES256)
+	if _, err := w.CreateHeader(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		if f.Name == "dir/" {
+			if f.IsEncrypted() {
+				t.Errorf("directory is encrypted")
+			}
+			continue
+		}
+		if f.Method != winZipAES || !f.IsEncrypted() {
+			t.Errorf("file %q: got method %d, want encrypted method %d", f.Name, f.Method, winZipAES)
+		}
+		if f.CRC32 != 0 {
+			t.Errorf("file %q: got CRC-32 %#x, want 0 for AE-2", f.Name, f.CRC32)
+		}
+		want := data
+		if f.Name == "empty" {
+			want = ""
+		}
+		testOpenWithPassword(t, f, "password", want)
+	}
+}
+
+func TestWinZipAESTampered(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	fh := &FileHeader{Name: "file", Method: Store}
+	fh.SetPassword("password", AES128)
+	fw, err := w.CreateHeader(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(fw, "authenticated data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := r.File[0].DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a bit of the encrypted data after the salt and the verifier.
+	data[offset+int64(AES128.saltLen()+aesVerifierLen)] ^= 1
+	rc, err := r.File[0].OpenWithPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); !errors.Is(err, ErrChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestUpdaterWinZipAES(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := &FileHeader{Name: "foo", Method: Deflate}
+	fh.SetPassword("password", AES256)
+	w, err := u.AppendHeader(fh, APPEND_MODE_OVERWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "secret data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	foo := testFileByName(t, r, "foo")
+	if !foo.IsEncrypted() {
+		t.Fatal("foo is not encrypted")
+	}
+	testOpenWithPassword(t, foo, "password", "secret data")
+	testReadFile(t, testFileByName(t, r, "bar"), &overwriteTestsOriginal[2])
+}
@@ -0,0 +1,423 @@
+package zip
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Deflate64 is the enhanced DEFLATE of PKWARE, which is written by Windows
+// for large files. It differs from DEFLATE by the 64 KiB window, the length
+// code 285 with 16 extra bits, and the distance codes 30 and 31.
+const (
+	deflate64WindowSize = 1 << 16
+	deflate64MaxBits    = 15 // longest Huffman code
+	deflate64NumLit     = 288
+	deflate64NumDist    = 32
+	deflate64NumCodeLen = 19
+)
+
+var (
+	deflate64LengthBase = [29]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3,
+	}
+	deflate64LengthExtra = [29]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16,
+	}
+	deflate64DistBase = [deflate64NumDist]uint32{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577, 32769, 49153,
+	}
+	deflate64DistExtra = [deflate64NumDist]uint8{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14,
+	}
+	deflate64CodeLenOrder = [deflate64NumCodeLen]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+// huffman64 decodes the canonical Huffman codes by a table indexed by the
+// next maxBits bits of the input, whose entries are the symbol shifted left
+// by 4 bits and the length of the code. A zero entry is an invalid code.
+type huffman64 struct {
+	table   []uint16
+	maxBits uint
+}
+
+// init builds the table of the code lengths. It reports false if the lengths
+// are over-subscribed.
+func (h *huffman64) init(lengths []uint8) bool {
+	var count [deflate64MaxBits + 1]int
+	var maxBits uint
+	for _, n := range lengths {
+		count[n]++
+		maxBits = max(maxBits, uint(n))
+	}
+	count[0] = 0
+	var code int
+	var next [deflate64MaxBits + 1]int
+	for n := 1; n <= deflate64MaxBits; n++ {
+		code = (code + count[n-1]) << 1
+		next[n] = code
+		if next[n]+count[n] > 1<<n {
+			return false
+		}
+	}
+	h.maxBits = maxBits
+	if cap(h.table) < 1<<maxBits {
+		h.table = make([]uint16, 1<<maxBits)
+	}
+	h.table = h.table[:1<<maxBits]
+	clear(h.table)
+	for sym, n := range lengths {
+		if n == 0 {
+			continue
+		}
+		code := next[n]
+		next[n]++
+		// The codes are packed starting with the most significant bit, so
+		// the table is indexed by the reversed code.
+		var rev int
+		for i := 0; i < int(n); i++ {
+			rev = rev<<1 | code>>i&1
+		}
+		for i := rev; i < len(h.table); i += 1 << n {
+			h.table[i] = uint16(sym)<<4 | uint16(n)
+		}
+	}
+	return true
+}
+
+var (
+	fixedHuffman64Once sync.Once
+	fixedLit64         huffman64
+	fixedDist64        huffman64
+)
+
+func fixedHuffman64() (lit, dist *huffman64) {
+	fixedHuffman64Once.Do(func() {
+		var lengths [deflate64NumLit]uint8
+		for i := range lengths {
+			switch {
+			case i < 144:
+				lengths[i] = 8
+			case i < 256:
+				lengths[i] = 9
+			case i < 280:
+				lengths[i] = 7
+			default:
+				lengths[i] = 8
+			}
+		}
+		fixedLit64.init(lengths[:])
+		var dlengths [deflate64NumDist]uint8
+		for i := range dlengths {
+			dlengths[i] = 5
+		}
+		fixedDist64.init(dlengths[:])
+	})
+	return &fixedLit64, &fixedDist64
+}
+
+// deflate64Reader decompresses a Deflate64 stream. The decompressed data are
+// written to the window, and returned by Read before they are overwritten.
+type deflate64Reader struct {
+	r     *bufio.Reader
+	bits  uint64
+	nbits uint
+	eof   bool // no more input for bits
+
+	hist  []byte
+	wpos  int  // write position in hist
+	rpos  int  // read position in hist
+	full  bool // hist has been filled at least once
+	final bool // the current block is the last one
+
+	stored   int // remaining bytes of the stored block
+	lit      *huffman64
+	dist     *huffman64
+	copyLen  int
+	copyDist int
+
+	dynLit, dynDist huffman64
+	err             error
+}
+
+func newDeflate64Reader(r io.Reader) io.ReadCloser {
+	return &deflate64Reader{
+		r:    bufio.NewReader(r),
+		hist: make([]byte, deflate64WindowSize),
+	}
+}
+
+func (d *deflate64Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if d.rpos < d.wpos {
+			n := copy(p, d.hist[d.rpos:d.wpos])
+			d.rpos += n
+			return n, nil
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.wpos == len(d.hist) {
+			d.wpos, d.rpos, d.full = 0, 0, true
+		}
+		d.err = d.step()
+	}
+}
+
+func (d *deflate64Reader) Close() error {
+	if d.err == io.EOF {
+		return nil
+	}
+	return d.err
+}
+
+// step decompresses data until the window is full or the block ends.
+func (d *deflate64Reader) step() error {
+	switch {
+	case d.copyLen > 0:
+		d.copy()
+		return nil
+	case d.stored > 0:
+		return d.readStored()
+	case d.lit != nil:
+		return d.decodeBlock()
+	case d.final:
+		return io.EOF
+	}
+	return d.readBlockHeader()
+}
+
+// refill reads bytes into the bit buffer until it holds at least n bits. At
+// the end of the input, it returns with fewer bits.
+func (d *deflate64Reader) refill(n uint) error {
+	for d.nbits < n && !d.eof {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			d.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		d.bits |= uint64(b) << d.nbits
+		d.nbits += 8
+	}
+	return nil
+}
+
+func (d *deflate64Reader) readBits(n uint) (uint32, error) {
+	if err := d.refill(n); err != nil {
+		return 0, err
+	}
+	if d.nbits < n {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := uint32(d.bits & (1<<n - 1))
+	d.bits >>= n
+	d.nbits -= n
+	return v, nil
+}
+
+func (d *deflate64Reader) decodeSymbol(h *huffman64) (int, error) {
+	if err := d.refill(h.maxBits); err != nil {
+		return 0, err
+	}
+	e := h.table[d.bits&(1<<h.maxBits-1)]
+	n := uint(e & 0xf)
+	if n == 0 {
+		if d.nbits < h.maxBits {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, ErrFormat
+	}
+	if n > d.nbits {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.bits >>= n
+	d.nbits -= n
+	return int(e >> 4), nil
+}
+
+func (d *deflate64Reader) readBlockHeader() error {
+	v, err := d.readBits(3)
+	if err != nil {
+		return err
+	}
+	d.final = v&1 != 0
+	switch v >> 1 {
+	case 0:
+		// Stored block, skip to the byte boundary and read LEN and NLEN.
+		d.bits >>= d.nbits % 8
+		d.nbits -= d.nbits % 8
+		v, err := d.readBits(32)
+		if err != nil {
+			return err
+		}
+		if uint16(v) != ^uint16(v>>16) {
+			return ErrFormat
+		}
+		d.stored = int(uint16(v))
+		return nil
+	case 1:
+		d.lit, d.dist = fixedHuffman64()
+		return nil
+	case 2:
+		return d.readDynamicHeader()
+	}
+	return ErrFormat
+}
+
+func (d *deflate64Reader) readDynamicHeader() error {
+	v, err := d.readBits(14)
+	if err != nil {
+		return err
+	}
+	nlit := int(v&0x1f) + 257
+	ndist := int(v>>5&0x1f) + 1
+	nclen := int(v>>10) + 4
+	var clen [deflate64NumCodeLen]uint8
+	for i := 0; i < nclen; i++ {
+		v, err := d.readBits(3)
+		if err != nil {
+			return err
+		}
+		clen[deflate64CodeLenOrder[i]] = uint8(v)
+	}
+	var h huffman64
+	if !h.init(clen[:]) {
+		return ErrFormat
+	}
+	var lengths [deflate64NumLit + deflate64NumDist]uint8
+	for i := 0; i < nlit+ndist; {
+		sym, err := d.decodeSymbol(&h)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var rep int
+		var length uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return ErrFormat
+			}
+			length = lengths[i-1]
+			v, err = d.readBits(2)
+			rep = 3 + int(v)
+		case 17:
+			v, err = d.readBits(3)
+			rep = 3 + int(v)
+		default:
+			v, err = d.readBits(7)
+			rep = 11 + int(v)
+		}
+		if err != nil {
+			return err
+		}
+		if i+rep > nlit+ndist {
+			return ErrFormat
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = length
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		// The block cannot end without the end of block code.
+		return ErrFormat
+	}
+	if !d.dynLit.init(lengths[:nlit]) || !d.dynDist.init(lengths[nlit:nlit+ndist]) {
+		return ErrFormat
+	}
+	d.lit, d.dist = &d.dynLit, &d.dynDist
+	return nil
+}
+
+func (d *deflate64Reader) readStored() error {
+	n := min(d.stored, len(d.hist)-d.wpos)
+	var i int
+	// Take the bytes left in the bit buffer first.
+	for ; i < n && d.nbits >= 8; i++ {
+		d.hist[d.wpos+i] = byte(d.bits)
+		d.bits >>= 8
+		d.nbits -= 8
+	}
+	if _, err := io.ReadFull(d.r, d.hist[d.wpos+i:d.wpos+n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.wpos += n
+	d.stored -= n
+	return nil
+}
+
+func (d *deflate64Reader) decodeBlock() error {
+	for d.wpos < len(d.hist) {
+		sym, err := d.decodeSymbol(d.lit)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 256:
+			d.hist[d.wpos] = byte(sym)
+			d.wpos++
+			continue
+		case sym == 256:
+			d.lit, d.dist = nil, nil
+			return nil
+		case sym > 285:
+			return ErrFormat
+		}
+		sym -= 257
+		v, err := d.readBits(uint(deflate64LengthExtra[sym]))
+		if err != nil {
+			return err
+		}
+		length := int(deflate64LengthBase[sym]) + int(v)
+		sym, err = d.decodeSymbol(d.dist)
+		if err != nil {
+			return err
+		}
+		v, err = d.readBits(uint(deflate64DistExtra[sym]))
+		if err != nil {
+			return err
+		}
+		dist := int(deflate64DistBase[sym]) + int(v)
+		if !d.full && dist > d.wpos {
+			return ErrFormat
+		}
+		d.copyLen, d.copyDist = length, dist
+		d.copy()
+	}
+	return nil
+}
+
+// copy copies the pending match until the window is full.
+func (d *deflate64Reader) copy() {
+	src := d.wpos - d.copyDist
+	if src < 0 {
+		src += len(d.hist)
+	}
+	for d.copyLen > 0 && d.wpos < len(d.hist) {
+		d.hist[d.wpos] = d.hist[src]
+		d.wpos++
+		src++
+		if src == len(d.hist) {
+			src = 0
+		}
+		d.copyLen--
+	}
+}
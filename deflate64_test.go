@@ -0,0 +1,222 @@
+package zip
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// deflate64BitWriter writes a Deflate64 stream of fixed Huffman codes.
+type deflate64BitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *deflate64BitWriter) writeBits(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes a Huffman code, which is packed starting with the most
+// significant bit.
+func (w *deflate64BitWriter) writeCode(code uint32, n uint) {
+	var rev uint32
+	for i := uint(0); i < n; i++ {
+		rev = rev<<1 | code>>i&1
+	}
+	w.writeBits(rev, n)
+}
+
+func (w *deflate64BitWriter) writeLiteral(sym int) {
+	switch {
+	case sym < 144:
+		w.writeCode(0x30+uint32(sym), 8)
+	case sym < 256:
+		w.writeCode(0x190+uint32(sym-144), 9)
+	case sym < 280:
+		w.writeCode(uint32(sym-256), 7)
+	default:
+		w.writeCode(0xc0+uint32(sym-280), 8)
+	}
+}
+
+// writeMatch writes a match with the length code and the distance code,
+// and the extra bits of their values.
+func (w *deflate64BitWriter) writeMatch(length, dist int) {
+	i := len(deflate64LengthBase) - 1 // the length code 285 for long matches
+	if length <= 258 {
+		for i = 0; i+1 < len(deflate64LengthBase)-1 && int(deflate64LengthBase[i+1]) <= length; i++ {
+		}
+	}
+	w.writeLiteral(257 + i)
+	w.writeBits(uint32(length-int(deflate64LengthBase[i])), uint(deflate64LengthExtra[i]))
+	for i := len(deflate64DistBase) - 1; i >= 0; i-- {
+		if base := int(deflate64DistBase[i]); dist >= base {
+			w.writeCode(uint32(i), 5)
+			w.writeBits(uint32(dist-base), uint(deflate64DistExtra[i]))
+			break
+		}
+	}
+}
+
+func (w *deflate64BitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// deflate64Stream returns a stream of a fixed Huffman block using the length
+// code 285 and the distance codes 30 and 31 of Deflate64, and the data it
+// decompresses to.
+func deflate64Stream() (stream, want []byte) {
+	var w deflate64BitWriter
+	w.writeBits(1, 1) // final block
+	w.writeBits(1, 2) // fixed Huffman codes
+	match := func(length, dist int) {
+		w.writeMatch(length, dist)
+		for i := 0; i < length; i++ {
+			want = append(want, want[len(want)-dist])
+		}
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		w.writeLiteral(int(c))
+		want = append(want, byte(c))
+	}
+	match(3+1<<16-1, 26) // longest match of the length code 285
+	match(1000, 26)
+	for i := 0; i < 256; i++ {
+		w.writeLiteral(i)
+		want = append(want, byte(i))
+	}
+	match(10, 40000)       // distance code 30
+	match(300, 1<<16-1000) // distance code 31
+	match(258, 1)
+	w.writeLiteral(256) // end of block
+	return w.bytes(), want
+}
+
+func testDeflate64(t *testing.T, stream, want []byte) {
+	t.Helper()
+	rc := newDeflate64Reader(bytes.NewReader(stream))
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestDeflate64(t *testing.T) {
+	stream, want := deflate64Stream()
+	testDeflate64(t, stream, want)
+}
+
+func TestDeflate64Deflate(t *testing.T) {
+	// DEFLATE streams without the length code 285, which is the only
+	// difference of the codes, are valid Deflate64 streams. Random text has
+	// no matches of 258 bytes.
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = "abcdefgh \n"[rnd.Intn(10)]
+	}
+	for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.BestCompression, flate.HuffmanOnly} {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		testDeflate64(t, buf.Bytes(), data)
+	}
+}
+
+func TestDeflate64Corrupt(t *testing.T) {
+	stream, _ := deflate64Stream()
+	tests := []struct {
+		name   string
+		stream []byte
+		err    error
+	}{
+		{"truncated", stream[:len(stream)/2], io.ErrUnexpectedEOF},
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"reserved block type", []byte{0x07}, ErrFormat},
+		{"stored length", []byte{0x01, 0x05, 0x00, 0x00, 0x00}, ErrFormat},
+		{"distance too far", func() []byte {
+			var w deflate64BitWriter
+			w.writeBits(1, 1)
+			w.writeBits(1, 2)
+			w.writeLiteral('a')
+			w.writeMatch(3, 2)
+			w.writeLiteral(256)
+			return w.bytes()
+		}(), ErrFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := io.ReadAll(newDeflate64Reader(bytes.NewReader(test.stream)))
+			if !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestReaderDeflate64(t *testing.T) {
+	stream, want := deflate64Stream()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	fw, err := w.CreateRaw(&FileHeader{
+		Name:               "file",
+		Method:             Deflate64,
+		CRC32:              crc32.ChecksumIEEE(want),
+		CompressedSize64:   uint64(len(stream)),
+		UncompressedSize64: uint64(len(want)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := r.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
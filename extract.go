@@ -0,0 +1,271 @@
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ExtractOptions configures [Reader.ExtractTo]. The zero value extracts all
+// the files without limits.
+type ExtractOptions struct {
+	// SkipInsecure skips the files with insecure names, see [NewReader],
+	// instead of failing with [ErrInsecurePath].
+	SkipInsecure bool
+
+	// ReaderOptions are the limits of the files extracted, checked in
+	// addition to the ones of the Reader. MaxTotalSize limits the total
+	// uncompressed size of the files, and MaxEntries their number including
+	// the directories and the symbolic links.
+	ReaderOptions
+}
+
+// check returns a [*LimitError] if the files exceed the limits. The sizes
+// declared by the files are checked, which the readers of [File.Open] do not
+// read beyond.
+func (opts *ExtractOptions) check(files []*File) error {
+	if err := opts.checkEntries(uint64(len(files))); err != nil {
+		return err
+	}
+	var total uint64
+	for _, f := range files {
+		if err := opts.checkFile(f); err != nil {
+			return err
+		}
+		size := f.UncompressedSize64
+		total += size
+		if opts.MaxTotalSize > 0 && (total < size || total > uint64(opts.MaxTotalSize)) {
+			return &LimitError{Limit: "MaxTotalSize"}
+		}
+	}
+	return nil
+}
+
+// ExtractTo extracts the files of the archive into the directory dir, which
+// is created if it does not exist. The existing files are overwritten.
+//
+// The files with insecure names, see [NewReader], are rejected with
+// [ErrInsecurePath] or skipped as configured by opts. The symbolic links are
+// created only if their targets are local to dir without going through other
+// symbolic links of the archive, and ExtractTo never writes through the
+// symbolic links created by the archive, so a file in a directory replaced by
+// a symbolic link fails with ErrInsecurePath. Other kinds of special files are
+// not supported.
+//
+// The permission bits of [FileHeader.Mode] and the [FileHeader.Modified]
+// time are applied to the files and the directories, except that the times
+// of the symbolic links are left as is. The limits of opts are checked before
+// any file is extracted, and a [*LimitError] is returned if they are exceeded.
+func (r *Reader) ExtractTo(dir string, opts ExtractOptions) error {
+	files := make([]*File, 0, len(r.File))
+	for _, f := range r.File {
+		if f.Name == "" {
+			continue
+		}
+		if insecurePath(f.Name) {
+			if opts.SkipInsecure {
+				continue
+			}
+			return fmt.Errorf("zip: file %q: %w", f.Name, ErrInsecurePath)
+		}
+		files = append(files, f)
+	}
+	if err := opts.check(files); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	links := &extractLinks{names: make(map[string]bool), paths: make(map[string]bool)}
+	var dirs []*File
+	for _, f := range files {
+		name := filepath.Clean(filepath.FromSlash(f.Name))
+		if name == "." {
+			continue
+		}
+		if links.contains(name) {
+			return fmt.Errorf("zip: file %q is in a symbolic link: %w", f.Name, ErrInsecurePath)
+		}
+		target := filepath.Join(dir, name)
+		mode := f.Mode()
+		var err error
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0755)
+			dirs = append(dirs, f)
+		case mode&fs.ModeSymlink != 0:
+			err = extractSymlink(f, name, target, links)
+		case mode.Type() == 0:
+			err = extractFile(f, target)
+		default:
+			err = fmt.Errorf("zip: file %q: cannot extract %v", f.Name, mode.Type())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The modes and times of the directories are applied after their contents
+	// are extracted, which may need to write into them and change their
+	// times, and so to the subdirectories before their parents.
+	slices.SortFunc(dirs, func(a, b *File) int {
+		return strings.Compare(b.Name, a.Name)
+	})
+	for _, f := range dirs {
+		target := filepath.Join(dir, filepath.Clean(filepath.FromSlash(f.Name)))
+		if err := setModeTime(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes the content of the regular file f to target.
+func extractFile(f *File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeLink(target); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	defer rc.Close()
+	w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Close()
+		return fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return setModeTime(f, target)
+}
+
+// extractLinks are the symbolic links created by ExtractTo, keyed by their
+// lowercased names to also catch them on case-insensitive file systems.
+type extractLinks struct {
+	names map[string]bool // the names of the links
+	paths map[string]bool // the names the targets of the links go through
+}
+
+// contains reports whether name is a link or in a link.
+func (l *extractLinks) contains(name string) bool {
+	for p := name; p != "."; p = filepath.Dir(p) {
+		if l.names[strings.ToLower(p)] {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the link name to target, where name is a clean local path. It
+// reports false if the target, resolved from the directory of the link one
+// name at a time, leaves the directory extracted into or goes through a link,
+// or if the target of another link goes through name. The links going through
+// other links are rejected since they could be chained to escape, like "a" to
+// "." and "b" to "a/..".
+func (l *extractLinks) add(name, target string) bool {
+	if l.paths[strings.ToLower(name)] {
+		return false
+	}
+	var elems, paths []string
+	if dir := filepath.Dir(name); dir != "." {
+		elems = strings.Split(dir, string(filepath.Separator))
+	}
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(elems) == 0 {
+				return false
+			}
+			elems = elems[:len(elems)-1]
+			continue
+		}
+		elems = append(elems, elem)
+		p := strings.ToLower(filepath.Join(elems...))
+		if l.names[p] {
+			return false
+		}
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		l.paths[p] = true
+	}
+	l.names[strings.ToLower(name)] = true
+	return true
+}
+
+// extractSymlink creates the symbolic link f named name at target, if its
+// target is local to the directory extracted into, see [extractLinks.add].
+func extractSymlink(f *File, name, target string, links *extractLinks) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	b, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	link := string(b)
+	if link == "" || path.IsAbs(link) || filepath.IsAbs(link) || insecurePath(path.Join(path.Dir(f.Name), link)) ||
+		!links.add(name, link) {
+		return fmt.Errorf("zip: symbolic link %q to %q: %w", f.Name, link, ErrInsecurePath)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeLink(target); err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(link), target)
+}
+
+// removeLink removes the existing file at target unless it is a directory,
+// so that a symbolic link there is replaced instead of followed.
+func removeLink(target string) error {
+	fi, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && fi.IsDir() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(target)
+}
+
+// setModeTime applies the permission bits and the modification time of f to
+// target. The files without permission bits, such as the ones created by the
+// tools that do not store them, get the default ones.
+func setModeTime(f *File, target string) error {
+	mode := f.Mode()
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0644
+		if mode.IsDir() {
+			perm = 0755
+		}
+	}
+	if err := os.Chmod(target, perm); err != nil {
+		return err
+	}
+	if f.Modified.IsZero() {
+		return nil
+	}
+	return os.Chtimes(target, f.Modified, f.Modified)
+}
@@ -0,0 +1,234 @@
+package zip
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type extractTestFile struct {
+	Name    string
+	Mode    fs.FileMode
+	Content string
+}
+
+func newExtractTestReader(t *testing.T, files []extractTestFile) *Reader {
+	t.Helper()
+	tests := make([]WriteTest, len(files))
+	for i, file := range files {
+		tests[i] = WriteTest{
+			Name:     file.Name,
+			Data:     []byte(file.Content),
+			Method:   Deflate,
+			Mode:     file.Mode,
+			Modified: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC),
+		}
+	}
+	return openTestZip(t, createTestZip(t, tests))
+}
+
+func TestExtractTo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links and permission bits are not supported")
+	}
+	r := newExtractTestReader(t, []extractTestFile{
+		{"dir/", fs.ModeDir | 0750, ""},
+		{"dir/file", 0640, "file in dir"},
+		{"dir/sub/exec", 0755, "#!/bin/sh\n"},
+		{"readonly/", fs.ModeDir | 0555, ""},
+		{"readonly/file", 0444, "read-only file"},
+		{"link", fs.ModeSymlink | 0777, "dir/file"},
+		{"dir/sub/link", fs.ModeSymlink | 0777, "../file"},
+	})
+	dir := t.TempDir()
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "readonly"), 0755) })
+	if err := r.ExtractTo(dir, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	modified := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	for _, test := range []struct {
+		name    string
+		mode    fs.FileMode
+		content string
+	}{
+		{"dir", fs.ModeDir | 0750, ""},
+		{"dir/file", 0640, "file in dir"},
+		{"dir/sub/exec", 0755, "#!/bin/sh\n"},
+		{"readonly", fs.ModeDir | 0555, ""},
+		{"readonly/file", 0444, "read-only file"},
+		{"link", fs.ModeSymlink, "file in dir"},
+		{"dir/sub/link", fs.ModeSymlink, "file in dir"},
+	} {
+		name := filepath.Join(dir, test.name)
+		fi, err := os.Lstat(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if test.mode&fs.ModeSymlink != 0 {
+			if fi.Mode().Type() != fs.ModeSymlink {
+				t.Errorf("%s: got mode %v, want a symbolic link", test.name, fi.Mode())
+			}
+		} else {
+			if fi.Mode() != test.mode {
+				t.Errorf("%s: got mode %v, want %v", test.name, fi.Mode(), test.mode)
+			}
+			if !fi.ModTime().Equal(modified) {
+				t.Errorf("%s: got time %v, want %v", test.name, fi.ModTime(), modified)
+			}
+		}
+		if fi.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != test.content {
+			t.Errorf("%s: got content %q, want %q", test.name, b, test.content)
+		}
+	}
+
+}
+
+func TestExtractToOverwrite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links are not supported")
+	}
+	// An existing symbolic link is replaced instead of followed.
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(out, "file")); err != nil {
+		t.Fatal(err)
+	}
+	r := newExtractTestReader(t, []extractTestFile{{"file", 0644, "new"}})
+	if err := r.ExtractTo(out, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(outside); err != nil || string(b) != "outside" {
+		t.Errorf("got outside file %q, %v, want %q", b, err, "outside")
+	}
+	fi, err := os.Lstat(filepath.Join(out, "file"))
+	if err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("got %v, %v, want a regular file", fi, err)
+	}
+}
+
+func TestExtractToInsecure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links are not supported")
+	}
+	tests := []struct {
+		name  string
+		files []extractTestFile
+	}{
+		{"parent", []extractTestFile{{"../evil", 0644, "evil"}}},
+		{"absolute", []extractTestFile{{"/tmp/evil", 0644, "evil"}}},
+		{"backslash", []extractTestFile{{`dir\..\..\evil`, 0644, "evil"}}},
+		{"link to parent", []extractTestFile{{"link", fs.ModeSymlink | 0777, "../outside"}}},
+		{"absolute link", []extractTestFile{{"link", fs.ModeSymlink | 0777, "/etc"}}},
+		{"through link", []extractTestFile{
+			{"dir/", fs.ModeDir | 0755, ""},
+			{"link", fs.ModeSymlink | 0777, "dir"},
+			{"link/evil", 0644, "evil"},
+		}},
+		{"through link case", []extractTestFile{
+			{"dir/", fs.ModeDir | 0755, ""},
+			{"Link", fs.ModeSymlink | 0777, "dir"},
+			{"link/evil", 0644, "evil"},
+		}},
+		{"through link dotdot", []extractTestFile{
+			{"dir/", fs.ModeDir | 0755, ""},
+			{"link", fs.ModeSymlink | 0777, "dir"},
+			{"dir/../link/evil", 0644, "evil"},
+		}},
+		{"link chain", []extractTestFile{
+			{"x", fs.ModeSymlink | 0777, "."},
+			{"y", fs.ModeSymlink | 0777, "x/.."},
+		}},
+		{"link chain reversed", []extractTestFile{
+			{"y", fs.ModeSymlink | 0777, "x/.."},
+			{"x", fs.ModeSymlink | 0777, "."},
+		}},
+		{"link chain case", []extractTestFile{
+			{"dir/", fs.ModeDir | 0755, ""},
+			{"dir/X", fs.ModeSymlink | 0777, ".."},
+			{"y", fs.ModeSymlink | 0777, "dir/x/.."},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newExtractTestReader(t, test.files)
+			dir := filepath.Join(t.TempDir(), "out")
+			err := r.ExtractTo(dir, ExtractOptions{})
+			if !errors.Is(err, ErrInsecurePath) {
+				t.Errorf("got error %v, want %v", err, ErrInsecurePath)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "dir/evil")); err == nil {
+				t.Error("the file is written through the symbolic link")
+			}
+		})
+	}
+
+	r := newExtractTestReader(t, []extractTestFile{
+		{"../evil", 0644, "evil"},
+		{"good", 0644, "good"},
+	})
+	dir := t.TempDir()
+	if err := r.ExtractTo(filepath.Join(dir, "out"), ExtractOptions{SkipInsecure: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		t.Error("the insecure file is extracted")
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "out/good")); err != nil || string(b) != "good" {
+		t.Errorf("got %q, %v, want %q", b, err, "good")
+	}
+}
+
+func TestExtractToLimits(t *testing.T) {
+	r := newExtractTestReader(t, []extractTestFile{
+		{"dir/", fs.ModeDir | 0755, ""},
+		{"dir/zeros", 0644, strings.Repeat("\x00", 100000)},
+		{"dir/text", 0644, "text"},
+	})
+	tests := []struct {
+		opts ReaderOptions
+		err  *LimitError
+	}{
+		{ReaderOptions{MaxEntries: 2}, &LimitError{Limit: "MaxEntries"}},
+		{ReaderOptions{MaxTotalSize: 100003}, &LimitError{Limit: "MaxTotalSize"}},
+		{ReaderOptions{MaxRatio: 100}, &LimitError{Name: "dir/zeros", Limit: "MaxRatio"}},
+		{ReaderOptions{MaxFileSize: 99999}, &LimitError{Name: "dir/zeros", Limit: "MaxFileSize"}},
+		{ReaderOptions{MaxEntries: 3, MaxTotalSize: 100004, MaxRatio: 1000, MaxFileSize: 100000}, nil},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		err := r.ExtractTo(dir, ExtractOptions{ReaderOptions: test.opts})
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%+v: %v", test.opts, err)
+			}
+			continue
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) || *lerr != *test.err {
+			t.Errorf("%+v: got error %v, want %v", test.opts, err, test.err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "dir")); err == nil {
+			t.Errorf("%+v: files are extracted", test.opts)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package zip
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// WithSpaceReuse makes the [Updater] reuse the space of the overwritten and
+// deleted files instead of moving all the data after them.
+//
+// The space of a removed file is kept as free space. A file overwritten with
+// [APPEND_MODE_OVERWRITE] is written into the space of the original file,
+// and other new files are written into the largest free space. If the file
+// data does not fit, the data already written is moved to the end of the file
+// data and the free space is kept for later files. Files added by
+// [Updater.AppendRaw] and [Updater.Copy] have a known size and are written
+// into the smallest free space they fit in.
+//
+// The free space stays in the zip archive as unused bytes until
+// [Updater.Compact] is called.
+func WithSpaceReuse() UpdaterOption {
+	return func(u *Updater) {
+		u.reuseSpace = true
+	}
+}
+
+// fileEnd returns the end offset of the file h in the zip archive, including
+// the local file header, the file data and the data descriptor.
+func (u *Updater) fileEnd(h *header) (int64, error) {
+	return fileDataEnd(u.rw, h.FileHeader, int64(h.offset))
+}
+
+// initFreeSpace finds the free space between the files of the zip archive
+// and before the directory record.
+func (u *Updater) initFreeSpace() error {
+	u.free = nil
+	for i, h := range u.dir {
+		end, err := u.fileEnd(h)
+		if err != nil {
+			return fmt.Errorf("zip: file %q: %w", h.Name, err)
+		}
+		next := u.dirOffset
+		if i < len(u.dir)-1 {
+			next = int64(u.dir[i+1].offset)
+		}
+		if end < next {
+			u.free = append(u.free, [2]int64{end, next})
+		}
+	}
+	return nil
+}
+
+// addFree marks the range [start, end) as free space and returns the free
+// space merged with the adjacent ones.
+func (u *Updater) addFree(start, end int64) [2]int64 {
+	s := [2]int64{start, end}
+	if start >= end {
+		return s
+	}
+	i, _ := slices.BinarySearchFunc(u.free, start, func(f [2]int64, start int64) int {
+		return cmp.Compare(f[0], start)
+	})
+	if i > 0 && u.free[i-1][1] >= start {
+		i--
+		s[0] = u.free[i][0]
+		s[1] = max(s[1], u.free[i][1])
+	}
+	j := i
+	for j < len(u.free) && u.free[j][0] <= s[1] {
+		s[1] = max(s[1], u.free[j][1])
+		j++
+	}
+	u.free = slices.Replace(u.free, i, j, s)
+	return s
+}
+
+// takeFree removes the range [start, end) from the free space.
+func (u *Updater) takeFree(start, end int64) {
+	var free [][2]int64
+	for _, f := range u.free {
+		if f[1] <= start || f[0] >= end {
+			free = append(free, f)
+			continue
+		}
+		if f[0] < start {
+			free = append(free, [2]int64{f[0], start})
+		}
+		if f[1] > end {
+			free = append(free, [2]int64{end, f[1]})
+		}
+	}
+	u.free = free
+}
+
+// dataEnd returns the end offset of the file data, the free space after it
+// up to the directory record is not limited in size.
+func (u *Updater) dataEnd() int64 {
+	if n := len(u.free); n > 0 && u.free[n-1][1] >= u.dirOffset {
+		return u.free[n-1][0]
+	}
+	return u.dirOffset
+}
+
+// freeFile removes the file from the directory record and marks its space
+// as free. It returns the free space containing the removed file.
+func (u *Updater) freeFile(dirIndex int) ([2]int64, error) {
+	h := u.dir[dirIndex]
+	end, err := u.fileEnd(h)
+	if err != nil {
+		return [2]int64{}, fmt.Errorf("zip: file %q: %w", h.Name, err)
+	}
+	u.view = nil
+	u.dir = slices.Delete(u.dir, dirIndex, dirIndex+1)
+	return u.addFree(int64(h.offset), end), nil
+}
+
+// allocate finds the space to write the new file h into and seeks to its
+// offset, see [WithSpaceReuse]. If mode is [APPEND_MODE_OVERWRITE] and the
+// file name already exists in the zip archive, the space of the existing file
+// is freed first.
+func (u *Updater) allocate(h *header, mode AppendMode) (io.Writer, error) {
+	freed := [2]int64{-1, -1}
+	if mode == APPEND_MODE_OVERWRITE {
+		if i := slices.IndexFunc(u.dir, func(d *header) bool { return d.Name == h.Name }); i >= 0 {
+			var err error
+			if freed, err = u.freeFile(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The free space at the end of the file data is not a gap, since the
+	// file data written there is not limited.
+	gaps := u.free
+	if n := len(gaps); n > 0 && gaps[n-1][1] >= u.dirOffset {
+		gaps = gaps[:n-1]
+	}
+	var (
+		gap   [2]int64
+		found bool
+	)
+	// Files with the same name are written after the existing ones to keep
+	// their order in the zip archive.
+	if !slices.ContainsFunc(u.dir, func(d *header) bool { return d.Name == h.Name }) {
+		gap, found = findGap(gaps, h, freed)
+	}
+	if !found {
+		offset := u.dataEnd()
+		u.takeFree(offset, u.dirOffset)
+		h.offset = uint64(offset)
+		if _, err := u.rw.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return u.rw, nil
+	}
+
+	u.takeFree(gap[0], gap[1])
+	h.offset = uint64(gap[0])
+	if _, err := u.rw.Seek(gap[0], io.SeekStart); err != nil {
+		return nil, err
+	}
+	u.gap = &gapWriter{u: u, h: h, limit: gap[1]}
+	return u.gap, nil
+}
+
+// findGap returns the free space between the files to write the new file h
+// into. The freed space is the space of the overwritten file, if any.
+func findGap(gaps [][2]int64, h *header, freed [2]int64) ([2]int64, bool) {
+	size := int64(fileHeaderLen + len(h.Name) + len(h.Extra))
+	if !h.raw {
+		// The size of the compressed data is unknown, prefer the space of
+		// the overwritten file, or use the largest gap.
+		size += dataDescriptorLen
+		if freed[1]-freed[0] >= size && slices.Contains(gaps, freed) {
+			return freed, true
+		}
+		var largest [2]int64
+		for _, g := range gaps {
+			if g[1]-g[0] > largest[1]-largest[0] {
+				largest = g
+			}
+		}
+		return largest, largest[1]-largest[0] >= size
+	}
+
+	size += int64(h.CompressedSize64)
+	if h.hasDataDescriptor() {
+		if h.isZip64() {
+			size += dataDescriptor64Len
+		} else {
+			size += dataDescriptorLen
+		}
+	}
+	// Use the smallest gap the file fits in.
+	var (
+		best  [2]int64
+		found bool
+	)
+	for _, g := range gaps {
+		if g[1]-g[0] >= size && (!found || g[1]-g[0] < best[1]-best[0]) {
+			best, found = g, true
+		}
+	}
+	return best, found
+}
+
+// finishFree updates the free space after the last file is written and seeks
+// to the end of the file data, see [WithSpaceReuse].
+func (u *Updater) finishFree() error {
+	if !u.reuseSpace {
+		return nil
+	}
+	end, err := u.rw.offset()
+	if err != nil {
+		return err
+	}
+	if g := u.gap; g != nil && !g.spilled {
+		u.addFree(end, g.limit)
+	} else if end < u.dirOffset {
+		u.addFree(end, u.dirOffset)
+	}
+	u.gap = nil
+	_, err = u.rw.Seek(u.dataEnd(), io.SeekStart)
+	return err
+}
+
+// gapWriter writes a file into the free space between other files. If the
+// file does not fit, the data already written is moved to the end of the file
+// data, where the rest of the file is written.
+type gapWriter struct {
+	u       *Updater
+	h       *header
+	limit   int64 // end offset of the free space
+	written int64
+	spilled bool
+}
+
+func (w *gapWriter) Write(p []byte) (int, error) {
+	if !w.spilled && int64(w.h.offset)+w.written+int64(len(p)) > w.limit {
+		if err := w.spill(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.u.rw.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *gapWriter) spill() error {
+	u := w.u
+	start := int64(w.h.offset)
+	u.addFree(start, w.limit)
+	offset := u.dataEnd()
+	u.takeFree(offset, u.dirOffset)
+	if err := u.moveData(offset, start, start+w.written); err != nil {
+		return fmt.Errorf("zip: move file data: %w", err)
+	}
+	// The file is the last one in the zip archive now.
+	i := slices.Index(u.dir, w.h)
+	u.dir = append(slices.Delete(u.dir, i, i+1), w.h)
+	w.h.offset = uint64(offset)
+	w.spilled = true
+	u.view = nil
+	_, err := u.rw.Seek(offset+w.written, io.SeekStart)
+	return err
+}
+
+// CompactOptions configures [Updater.Compact].
+type CompactOptions struct {
+	// DropDuplicates removes the older files with the same name added by
+	// [APPEND_MODE_KEEP_ORIGINAL], only the newest one, which is the one
+	// with the largest header offset, is kept.
+	DropDuplicates bool
+}
+
+// Compact moves the files of the zip archive to remove the unused space
+// between them, such as the free space left by the files overwritten or
+// deleted when [WithSpaceReuse] is enabled, and the zero-filled space before
+// the directory record. The data before the first file is kept as is. The
+// opts may be nil to use the default options.
+//
+// If the underlying writer supports truncation like [os.File], the zip archive
+// is truncated after the last file, and the directory record is written there
+// by [Updater.Close]. Otherwise the unused space after the last file is
+// cleaned when writing the directory record.
+//
+// Compact reports the number of bytes reclaimed from the file data.
+func (u *Updater) Compact(opts *CompactOptions) (int64, error) {
+	if u.closed {
+		return 0, errors.New("zip: compact closed updater")
+	}
+	if opts == nil {
+		opts = &CompactOptions{}
+	}
+	if err := u.closeLast(); err != nil {
+		return 0, err
+	}
+	if opts.DropDuplicates {
+		u.dropDuplicates()
+	}
+
+	// The files are packed after the prefix of the zip archive.
+	wp := u.prefixLen
+	for _, h := range u.dir {
+		start := int64(h.offset)
+		end, err := u.fileEnd(h)
+		if err != nil {
+			return 0, fmt.Errorf("zip: file %q: %w", h.Name, err)
+		}
+		if start != wp {
+			if err := u.moveData(wp, start, end); err != nil {
+				return 0, fmt.Errorf("zip: compact: %w", err)
+			}
+			h.offset = uint64(wp)
+			u.view = nil
+		}
+		wp += end - start
+	}
+	reclaimed := u.dirOffset - wp
+	u.free = nil
+	u.dirOffset = wp
+	if _, err := u.rw.Seek(wp, io.SeekStart); err != nil {
+		return reclaimed, err
+	}
+	if err := u.rw.Truncate(wp); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return reclaimed, fmt.Errorf("zip: truncate: %w", err)
+	}
+	return reclaimed, nil
+}
+
+// dropDuplicates removes the files with the same name from the directory
+// record except the one with the largest header offset.
+func (u *Updater) dropDuplicates() {
+	seen := make(map[string]bool, len(u.dir))
+	var removed bool
+	for i := len(u.dir) - 1; i >= 0; i-- {
+		name := u.dir[i].Name
+		if seen[name] {
+			u.dir[i] = nil
+			removed = true
+			continue
+		}
+		seen[name] = true
+	}
+	if removed {
+		u.dir = slices.DeleteFunc(u.dir, func(h *header) bool { return h == nil })
+		u.view = nil
+	}
+}
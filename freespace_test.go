@@ -0,0 +1,233 @@
+package zip
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+// testFileByName returns the file with the given name in the zip archive.
+func testFileByName(t *testing.T, r *Reader, name string) *File {
+	t.Helper()
+	i := slices.IndexFunc(r.File, func(f *File) bool { return f.Name == name })
+	if i < 0 {
+		t.Fatalf("file %q not found", name)
+	}
+	return r.File[i]
+}
+
+func TestUpdaterSpaceReuse(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	r := openTestZip(t, f)
+	barOffset := testFileByName(t, r, "bar").headerOffset
+	setuidOffset := testFileByName(t, r, "setuid").headerOffset
+
+	u, err := NewUpdater(f, WithSpaceReuse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The replaced data of foo does not fit into the space of the original
+	// file, and is moved to the end of the file data.
+	testAppend(t, u, &overwriteTestsReplaced[0], APPEND_MODE_OVERWRITE)
+	// The replaced data of foo2 is written into the space of foo and foo2.
+	testAppend(t, u, &overwriteTestsReplaced[1], APPEND_MODE_OVERWRITE)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestZip(t, f)
+	if len(r.File) != len(overwriteTestsOriginal) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(overwriteTestsOriginal))
+	}
+	if got := testFileByName(t, r, "setuid").headerOffset; got != setuidOffset {
+		t.Errorf("setuid is moved from offset %d to %d", setuidOffset, got)
+	}
+	if got := r.File[0].Name; got != "foo2" {
+		t.Errorf("first file is %q, want %q", got, "foo2")
+	}
+	if got := r.File[len(r.File)-1].Name; got != "foo" {
+		t.Errorf("last file is %q, want %q", got, "foo")
+	}
+	for _, wt := range overwriteTestsReplaced[:2] {
+		testReadFile(t, testFileByName(t, r, wt.Name), &wt)
+	}
+	for _, wt := range overwriteTestsOriginal[2:] {
+		testReadFile(t, testFileByName(t, r, wt.Name), &wt)
+	}
+
+	// The free space left after foo2 is found when opening the zip archive
+	// again, and is reused by the raw file which fits in it.
+	u, err = NewUpdater(f, WithSpaceReuse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.free) != 1 || u.free[0][1] != barOffset {
+		t.Fatalf("got free space %v, want a gap before offset %d", u.free, barOffset)
+	}
+	wt := WriteTest{Name: "copied", Data: []byte("copied file"), Method: Deflate, Mode: 0644}
+	src := openTestZip(t, createTestZip(t, []WriteTest{wt}))
+	if err := u.Copy(src.File[0], APPEND_MODE_KEEP_ORIGINAL); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = openTestZip(t, f)
+	copied := testFileByName(t, r, "copied")
+	if copied.headerOffset >= barOffset {
+		t.Errorf("copied file is written at offset %d, want before %d", copied.headerOffset, barOffset)
+	}
+	testReadFile(t, copied, &wt)
+}
+
+func TestUpdaterSpaceReuseSpill(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f, WithSpaceReuse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Delete("foo2"); err != nil {
+		t.Fatal(err)
+	}
+	// The new file is written into the space of foo2 and moved to the end
+	// when its data exceeds the space.
+	w, err := u.AppendHeader(&FileHeader{Name: "new", Method: Store}, APPEND_MODE_OVERWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for i := 0; i < len(data); i += 100 {
+		if _, err := w.Write(data[i : i+100]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	if got := r.File[len(r.File)-1].Name; got != "new" {
+		t.Errorf("last file is %q, want %q", got, "new")
+	}
+	testReadFile(t, testFileByName(t, r, "new"), &WriteTest{Name: "new", Data: data, Mode: 0666})
+	for _, wt := range slices.Delete(slices.Clone(overwriteTestsOriginal), 1, 2) {
+		testReadFile(t, testFileByName(t, r, wt.Name), &wt)
+	}
+}
+
+func TestUpdaterCompact(t *testing.T) {
+	for _, truncate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("truncate=%v", truncate), func(t *testing.T) {
+			f := createTestZip(t, overwriteTestsOriginal)
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rws io.ReadWriteSeeker = f
+			if !truncate {
+				rws = struct{ io.ReadWriteSeeker }{f}
+			}
+			u, err := NewUpdater(rws, WithSpaceReuse())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := u.Delete("foo2", "setgid"); err != nil {
+				t.Fatal(err)
+			}
+			testAppend(t, u, &overwriteTestsReplaced[0], APPEND_MODE_OVERWRITE)
+			reclaimed, err := u.Compact(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reclaimed <= 0 {
+				t.Errorf("got %d bytes reclaimed, want > 0", reclaimed)
+			}
+			if err := u.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			newSize, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err = NewUpdater(f, WithSpaceReuse())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if truncate {
+				if len(u.free) != 0 {
+					t.Errorf("got free space %v after Compact", u.free)
+				}
+				if newSize >= size {
+					t.Errorf("got size %d after Compact, want less than %d", newSize, size)
+				}
+			} else {
+				// Only the cleaned space before the directory record is left.
+				if len(u.free) > 1 || len(u.free) == 1 && u.free[0][1] != u.dirOffset {
+					t.Errorf("got free space %v after Compact", u.free)
+				}
+				if end := u.dataEnd(); end != u.dirOffset {
+					b := make([]byte, u.dirOffset-end)
+					if _, err := u.rw.ReadAt(b, end); err != nil {
+						t.Fatal(err)
+					}
+					if slices.ContainsFunc(b, func(c byte) bool { return c != 0 }) {
+						t.Errorf("space before the directory record is not cleaned")
+					}
+				}
+			}
+
+			r := openTestZip(t, f)
+			// The replaced data of foo is written into the space of foo and foo2.
+			want := []WriteTest{
+				overwriteTestsReplaced[0],
+				overwriteTestsOriginal[2],
+				overwriteTestsOriginal[3],
+				overwriteTestsOriginal[5],
+				overwriteTestsOriginal[6],
+				overwriteTestsOriginal[7],
+			}
+			if len(r.File) != len(want) {
+				t.Fatalf("got %d files, want %d", len(r.File), len(want))
+			}
+			for i, wt := range want {
+				testReadFile(t, r.File[i], &wt)
+			}
+		})
+	}
+}
+
+func TestUpdaterCompactDropDuplicates(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAppend(t, u, &overwriteTestsReplaced[1], APPEND_MODE_KEEP_ORIGINAL)
+	reclaimed, err := u.Compact(&CompactOptions{DropDuplicates: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The original foo2 has a 300 bytes long data.
+	if reclaimed < int64(len(overwriteTestsOriginal[1].Data)) {
+		t.Errorf("got %d bytes reclaimed, want at least %d", reclaimed, len(overwriteTestsOriginal[1].Data))
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := slices.Delete(slices.Clone(overwriteTestsOriginal), 1, 2)
+	want = append(want, overwriteTestsReplaced[1])
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
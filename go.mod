@@ -1,3 +1,10 @@
 module github.com/STARRY-S/zip
 
 go 1.23
+
+require (
+	github.com/dsnet/compress v0.0.2-0.20230904184137-39efe44ab707
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.33.0
+)
@@ -0,0 +1,300 @@
+package zip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+)
+
+// The journal file of an Updater starts with a header containing the
+// journalMagic and the original size of the zip archive, followed by the
+// records of the original data overwritten by the Updater. Each record is
+// written and synced to the journal before the zip archive is modified.
+//
+//	header:  magic [8]byte | size uint64 | crc32 uint32
+//	record:  type uint8 | offset uint64 | length uint32 | data | crc32 uint32
+//
+// A commit record is written once the update is finished, the journal file is
+// removed after that.
+const (
+	journalMagic     = "ZIPJRNL1"
+	journalHeaderLen = 20 // magic + uint64 + uint32
+	journalRecordLen = 17 // uint8 + uint64 + uint32 + uint32, + data
+
+	journalRecordData   = 'D'
+	journalRecordCommit = 'C'
+)
+
+type syncer interface {
+	Sync() error
+}
+
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// journal records the original data of the zip archive overwritten by an
+// [Updater], to be able to roll back an interrupted update.
+type journal struct {
+	f    *os.File
+	path string
+	// size is the original size of the zip archive.
+	size int64
+	// saved is the sorted list of the ranges of original data already
+	// saved in the journal.
+	saved [][2]int64
+}
+
+// createJournal creates the journal file for the zip archive with the
+// original size.
+func createJournal(path string, size int64) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("zip: create journal: %w", err)
+	}
+	var buf [journalHeaderLen]byte
+	b := writeBuf(buf[:])
+	copy(b, journalMagic)
+	b = b[len(journalMagic):]
+	b.uint64(uint64(size))
+	b.uint32(crc32.ChecksumIEEE(buf[:journalHeaderLen-4]))
+	if _, err := f.Write(buf[:]); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("zip: write journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("zip: sync journal: %w", err)
+	}
+	return &journal{f: f, path: path, size: size}, nil
+}
+
+// save stores the original data of the zip archive in range
+// [offset, offset+n) into the journal before it is overwritten. Data beyond
+// the original size and data already saved are skipped.
+func (j *journal) save(r io.ReaderAt, offset, n int64) error {
+	end := min(offset+n, j.size)
+	if offset >= end {
+		return nil
+	}
+	var written bool
+	for _, s := range j.unsaved(offset, end) {
+		for p := s[0]; p < s[1]; {
+			size := min(s[1]-p, bufferSize)
+			if err := j.writeRecord(r, p, size); err != nil {
+				return fmt.Errorf("zip: write journal: %w", err)
+			}
+			p += size
+		}
+		j.markSaved(s[0], s[1])
+		written = true
+	}
+	if !written {
+		return nil
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("zip: sync journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) writeRecord(r io.ReaderAt, offset, n int64) error {
+	buf := make([]byte, journalRecordLen+n)
+	b := writeBuf(buf)
+	b.uint8(journalRecordData)
+	b.uint64(uint64(offset))
+	b.uint32(uint32(n))
+	if _, err := r.ReadAt(b[:n], offset); err != nil {
+		return err
+	}
+	b = b[n:]
+	b.uint32(crc32.ChecksumIEEE(buf[:len(buf)-4]))
+	_, err := j.f.Write(buf)
+	return err
+}
+
+// unsaved returns the ranges in [start, end) not saved in the journal yet.
+func (j *journal) unsaved(start, end int64) [][2]int64 {
+	var ranges [][2]int64
+	for _, s := range j.saved {
+		if s[1] <= start {
+			continue
+		}
+		if s[0] >= end {
+			break
+		}
+		if start < s[0] {
+			ranges = append(ranges, [2]int64{start, s[0]})
+		}
+		start = s[1]
+	}
+	if start < end {
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	return ranges
+}
+
+func (j *journal) markSaved(start, end int64) {
+	i, _ := slices.BinarySearchFunc(j.saved, start, func(s [2]int64, start int64) int {
+		switch {
+		case s[0] < start:
+			return -1
+		case s[0] > start:
+			return 1
+		}
+		return 0
+	})
+	j.saved = slices.Insert(j.saved, i, [2]int64{start, end})
+	// Merge the adjacent ranges.
+	merged := j.saved[:1]
+	for _, s := range j.saved[1:] {
+		last := &merged[len(merged)-1]
+		if s[0] <= last[1] {
+			last[1] = max(last[1], s[1])
+			continue
+		}
+		merged = append(merged, s)
+	}
+	j.saved = merged
+}
+
+// commit marks the update as finished and removes the journal file.
+func (j *journal) commit() error {
+	var buf [journalRecordLen]byte
+	b := writeBuf(buf[:])
+	b.uint8(journalRecordCommit)
+	b = b[12:] // skip offset and length
+	b.uint32(crc32.ChecksumIEEE(buf[:journalRecordLen-4]))
+	if _, err := j.f.Write(buf[:]); err != nil {
+		return fmt.Errorf("zip: write journal: %w", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("zip: sync journal: %w", err)
+	}
+	return j.remove()
+}
+
+// rollback restores the original data of the zip archive saved in the
+// journal and removes the journal file.
+func (j *journal) rollback(rw io.WriterAt) error {
+	if _, err := j.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := rollbackJournal(j.f, rw); err != nil {
+		return err
+	}
+	return j.remove()
+}
+
+func (j *journal) remove() error {
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
+
+// recoverJournal finishes an interrupted update of the zip archive rw if the
+// journal file at path exists. The original data is restored unless the
+// update was committed, then the journal file is removed.
+func recoverJournal(path string, rw io.WriterAt) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("zip: open journal: %w", err)
+	}
+	err = rollbackJournal(f, rw)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rollbackJournal reads the journal records from r and writes the original
+// data back to the zip archive rw, which is truncated to the original size.
+// Nothing is restored if the journal has a commit record.
+func rollbackJournal(r io.ReadSeeker, rw io.WriterAt) error {
+	var buf [journalHeaderLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		// The journal header is written before modifying the zip
+		// archive, nothing to restore.
+		return nil
+	}
+	b := readBuf(buf[len(journalMagic):])
+	size := int64(b.uint64())
+	if string(buf[:len(journalMagic)]) != journalMagic ||
+		b.uint32() != crc32.ChecksumIEEE(buf[:journalHeaderLen-4]) {
+		return errors.New("zip: invalid journal")
+	}
+
+	// Validate the records first, the last record may be incomplete if the
+	// update was interrupted while writing the journal.
+	var records int
+	for {
+		typ, _, _, err := readJournalRecord(r)
+		if err != nil {
+			break
+		}
+		if typ == journalRecordCommit {
+			// The update was finished.
+			return nil
+		}
+		records++
+	}
+
+	if _, err := r.Seek(journalHeaderLen, io.SeekStart); err != nil {
+		return err
+	}
+	for range records {
+		_, offset, data, err := readJournalRecord(r)
+		if err != nil {
+			return err
+		}
+		if _, err := rw.WriteAt(data, offset); err != nil {
+			return fmt.Errorf("zip: rollback: %w", err)
+		}
+	}
+	if t, ok := rw.(truncater); !ok {
+		return fmt.Errorf("zip: rollback: truncate: %w", errors.ErrUnsupported)
+	} else if err := t.Truncate(size); err != nil {
+		return fmt.Errorf("zip: rollback: truncate: %w", err)
+	}
+	if s, ok := rw.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			return fmt.Errorf("zip: rollback: sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// readJournalRecord reads and validates a record from the journal.
+func readJournalRecord(r io.Reader) (typ uint8, offset int64, data []byte, err error) {
+	var buf [journalRecordLen - 4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	b := readBuf(buf[:])
+	typ = b.uint8()
+	offset = int64(b.uint64())
+	n := int64(b.uint32())
+	if n > bufferSize {
+		return 0, 0, nil, ErrFormat
+	}
+	data = make([]byte, n+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, 0, nil, err
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(buf[:]), crc32.IEEETable, data[:n])
+	if binary.LittleEndian.Uint32(data[n:]) != crc {
+		return 0, 0, nil, ErrChecksum
+	}
+	return typ, offset, data[:n], nil
+}
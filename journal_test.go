@@ -0,0 +1,161 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// updateTestZip overwrites, deletes and appends files in the zip archive,
+// and compacts it.
+func updateTestZip(t *testing.T, u *Updater) {
+	t.Helper()
+	testAppend(t, u, &overwriteTestsReplaced[1], APPEND_MODE_OVERWRITE)
+	if err := u.Delete("setuid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Rename("foo", "foo-renamed"); err != nil {
+		t.Fatal(err)
+	}
+	testAppend(t, u, &WriteTest{Name: "appended", Data: []byte("appended data")}, APPEND_MODE_KEEP_ORIGINAL)
+	// The zip archive is truncated after the last file.
+	if _, err := u.Compact(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFileData(t *testing.T, f *os.File) []byte {
+	t.Helper()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUpdaterJournalRollback(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "test.journal")
+	f := createTestZip(t, overwriteTestsOriginal)
+	original := readTestFileData(t, f)
+
+	u, err := NewUpdater(f, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateTestZip(t, u)
+	if err := u.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal file is not removed: %v", err)
+	}
+	if got := readTestFileData(t, f); !bytes.Equal(got, original) {
+		t.Errorf("zip archive is not restored after rollback")
+	}
+	if err := u.Close(); err == nil {
+		t.Errorf("Close after Rollback: expected error")
+	}
+}
+
+func TestUpdaterJournalRecover(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "test.journal")
+	f := createTestZip(t, overwriteTestsOriginal)
+	original := readTestFileData(t, f)
+
+	u, err := NewUpdater(f, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateTestZip(t, u)
+	// Simulate the process crashed while writing the directory record,
+	// with an incomplete record at the end of the journal.
+	if err := u.closeLast(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.rw.Write([]byte("corrupted directory record")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.rw.journal.f.Write([]byte{journalRecordData, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	u.rw.journal.f.Close()
+
+	u, err = NewUpdater(f, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFileData(t, f); !bytes.Equal(got, original) {
+		t.Errorf("zip archive is not restored after recovery")
+	}
+	updateTestZip(t, u)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal file is not removed: %v", err)
+	}
+	r := openTestZip(t, f)
+	if len(r.File) != len(overwriteTestsOriginal) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(overwriteTestsOriginal))
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			t.Errorf("file %q: %v", f.Name, err)
+		}
+		rc.Close()
+	}
+}
+
+func TestUpdaterJournalCommitted(t *testing.T) {
+	dir := t.TempDir()
+	journal := filepath.Join(dir, "test.journal")
+	f := createTestZip(t, overwriteTestsOriginal)
+
+	u, err := NewUpdater(f, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateTestZip(t, u)
+	// Keep a link of the journal file to simulate the process crashed
+	// after the commit record is written.
+	link := filepath.Join(dir, "link.journal")
+	if err := os.Link(journal, link); err != nil {
+		t.Skip(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(journal); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("journal file is not removed: %v", err)
+	}
+	updated := readTestFileData(t, f)
+	if err := os.Rename(link, journal); err != nil {
+		t.Fatal(err)
+	}
+
+	// The committed changes should not be rolled back.
+	u, err = NewUpdater(f, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFileData(t, f); !bytes.Equal(got, updated) {
+		t.Errorf("committed changes are rolled back")
+	}
+	if err := u.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFileData(t, f); !bytes.Equal(got, updated) {
+		t.Errorf("zip archive is not restored after rollback")
+	}
+}
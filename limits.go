@@ -0,0 +1,170 @@
+package zip
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+)
+
+// ReaderOptions configures the limits of a [Reader] created by
+// [NewReaderWithOptions] or [OpenReaderWithOptions], and of [Reader.ExtractTo]
+// with [ExtractOptions], which protect the programs reading untrusted archives
+// from zip bombs. Zero means no limit.
+type ReaderOptions struct {
+	// MaxFileSize limits the uncompressed size of a file opened by
+	// [File.Open].
+	MaxFileSize int64
+
+	// MaxTotalSize limits the total size of the content read from the files
+	// of the archive, counting the files opened several times as many times.
+	MaxTotalSize int64
+
+	// MaxRatio limits the ratio of the uncompressed size of a file opened by
+	// File.Open to its compressed size.
+	MaxRatio int
+
+	// MaxEntries limits the number of files in the central directory.
+	MaxEntries int
+}
+
+// readerLimits is the state of the limits of a Reader.
+type readerLimits struct {
+	ReaderOptions
+	nread atomic.Uint64 // total size of the content read so far
+}
+
+// LimitError is returned when a file or the whole archive exceeds a limit,
+// such as [ReaderOptions.MaxFileSize].
+type LimitError struct {
+	Name  string // the file name, or empty for the limits of the whole archive
+	Limit string // the name of the limit, such as "MaxTotalSize"
+}
+
+func (e *LimitError) Error() string {
+	if e.Name == "" {
+		return "zip: archive exceeds " + e.Limit
+	}
+	return fmt.Sprintf("zip: file %q exceeds %s", e.Name, e.Limit)
+}
+
+// OverlapError is returned when the data of two files overlap, such as the
+// files of a zip bomb sharing the same compressed data. It wraps [ErrFormat].
+type OverlapError struct {
+	Name  string // the file overlapping the other one
+	Other string // the other file, or empty for the central directory
+}
+
+func (e *OverlapError) Error() string {
+	if e.Other == "" {
+		return fmt.Sprintf("zip: file %q overlaps the central directory", e.Name)
+	}
+	return fmt.Sprintf("zip: file %q overlaps file %q", e.Name, e.Other)
+}
+
+func (e *OverlapError) Unwrap() error { return ErrFormat }
+
+// NewReaderWithOptions is like [NewReader] but enforces the limits of opts.
+// In addition, it returns an [*OverlapError] if the ranges of two files,
+// from their local file headers to the end of their data, overlap, or if
+// the range of a file runs past the start of the central directory.
+//
+// The violations of the limits of the files, which are checked by
+// [File.Open] and the readers it returns, are reported by a [*LimitError].
+func NewReaderWithOptions(r io.ReaderAt, size int64, opts ReaderOptions) (*Reader, error) {
+	return newReader(r, size, &readerLimits{ReaderOptions: opts})
+}
+
+// OpenReaderWithOptions is like [OpenReader] but enforces the limits of
+// opts, see [NewReaderWithOptions].
+func OpenReaderWithOptions(name string, opts ReaderOptions) (*ReadCloser, error) {
+	return openReader(name, &readerLimits{ReaderOptions: opts})
+}
+
+// checkOpen returns a [*LimitError] if the file f cannot be opened within
+// the limits. The declared sizes are checked, which the readers of
+// [File.Open] do not read beyond.
+func (l *readerLimits) checkOpen(f *File) error {
+	if err := l.checkFile(f); err != nil {
+		return err
+	}
+	size := f.UncompressedSize64
+	if l.MaxTotalSize > 0 && size > uint64(l.MaxTotalSize)-min(l.nread.Load(), uint64(l.MaxTotalSize)) {
+		return &LimitError{Limit: "MaxTotalSize"}
+	}
+	return nil
+}
+
+// read counts n bytes read from the content of a file, and returns a
+// [*LimitError] if the total size exceeds the limit.
+func (l *readerLimits) read(n int) error {
+	if l.MaxTotalSize > 0 && l.nread.Add(uint64(n)) > uint64(l.MaxTotalSize) {
+		return &LimitError{Limit: "MaxTotalSize"}
+	}
+	return nil
+}
+
+// checkFile returns a [*LimitError] if the declared sizes of the file f
+// exceed MaxFileSize or MaxRatio.
+func (o *ReaderOptions) checkFile(f *File) error {
+	size := f.UncompressedSize64
+	if o.MaxFileSize > 0 && size > uint64(o.MaxFileSize) {
+		return &LimitError{Name: f.Name, Limit: "MaxFileSize"}
+	}
+	if o.MaxRatio > 0 && exceedsRatio(size, f.CompressedSize64, uint64(o.MaxRatio)) {
+		return &LimitError{Name: f.Name, Limit: "MaxRatio"}
+	}
+	return nil
+}
+
+// checkEntries returns a [*LimitError] if there are more than the maximum
+// number of files.
+func (o *ReaderOptions) checkEntries(n uint64) error {
+	if o.MaxEntries > 0 && n > uint64(o.MaxEntries) {
+		return &LimitError{Limit: "MaxEntries"}
+	}
+	return nil
+}
+
+// checkOverlap returns an [*OverlapError] if the data of two files overlap,
+// or if the data of a file runs past the start of the directory record.
+// The range of a file is at least its local file header without the name and
+// extra fields, and its compressed data.
+func (r *Reader) checkOverlap() error {
+	files := slices.Clone(r.File)
+	slices.SortStableFunc(files, func(a, b *File) int {
+		return cmp.Compare(a.headerOffset, b.headerOffset)
+	})
+	for i, f := range files {
+		if i > 0 && !fitsBefore(files[i-1], f.headerOffset) {
+			return &OverlapError{Name: f.Name, Other: files[i-1].Name}
+		}
+		if !fitsBefore(f, r.dirOffset) {
+			return &OverlapError{Name: f.Name}
+		}
+	}
+	return nil
+}
+
+// fitsBefore reports whether the range of the file f ends at or before
+// offset.
+func fitsBefore(f *File, offset int64) bool {
+	if offset < f.headerOffset {
+		return false
+	}
+	gap := uint64(offset - f.headerOffset)
+	return gap >= fileHeaderLen && gap-fileHeaderLen >= f.CompressedSize64
+}
+
+// exceedsRatio reports whether usize is greater than csize*ratio, without
+// overflow.
+func exceedsRatio(usize, csize, ratio uint64) bool {
+	if usize == 0 {
+		return false
+	}
+	if csize == 0 {
+		return true
+	}
+	return (usize-1)/csize >= ratio
+}
@@ -0,0 +1,165 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// limitsTestZip returns an archive of a highly compressed file "zeros" of
+// 100000 bytes, and the files "a" and "b" of 100 bytes.
+func limitsTestZip(t *testing.T) []byte {
+	t.Helper()
+	f := createTestZip(t, []WriteTest{
+		{Name: "zeros", Data: make([]byte, 100000), Method: Deflate},
+		{Name: "a", Data: bytes.Repeat([]byte("a"), 100), Method: Deflate},
+		{Name: "b", Data: bytes.Repeat([]byte("b"), 100), Method: Deflate},
+	})
+	return readTestFileData(t, f)
+}
+
+func readLimitedFile(r *Reader, name string) error {
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	}
+	return os.ErrNotExist
+}
+
+func TestReaderOptions(t *testing.T) {
+	data := limitsTestZip(t)
+	tests := []struct {
+		opts  ReaderOptions
+		names []string // files to read, the last one fails if err is set
+		err   *LimitError
+	}{
+		{ReaderOptions{}, []string{"zeros", "a", "b"}, nil},
+		{ReaderOptions{MaxFileSize: 1000}, []string{"a", "b", "zeros"}, &LimitError{Name: "zeros", Limit: "MaxFileSize"}},
+		{ReaderOptions{MaxRatio: 100}, []string{"a", "b", "zeros"}, &LimitError{Name: "zeros", Limit: "MaxRatio"}},
+		{ReaderOptions{MaxTotalSize: 100200}, []string{"zeros", "a", "b"}, nil},
+		{ReaderOptions{MaxTotalSize: 100199}, []string{"zeros", "a", "b"}, &LimitError{Limit: "MaxTotalSize"}},
+		{ReaderOptions{MaxTotalSize: 250}, []string{"a", "b", "a"}, &LimitError{Limit: "MaxTotalSize"}},
+	}
+	for _, test := range tests {
+		r, err := NewReaderWithOptions(bytes.NewReader(data), int64(len(data)), test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range test.names {
+			err := readLimitedFile(r, name)
+			if i < len(test.names)-1 || test.err == nil {
+				if err != nil {
+					t.Errorf("%+v: file %q: %v", test.opts, name, err)
+				}
+				continue
+			}
+			var lerr *LimitError
+			if !errors.As(err, &lerr) || *lerr != *test.err {
+				t.Errorf("%+v: file %q: got error %v, want %v", test.opts, name, err, test.err)
+			}
+		}
+	}
+}
+
+func TestReaderOptionsTotalSizeRead(t *testing.T) {
+	// The files opened before reading any of them are limited while being
+	// read.
+	data := limitsTestZip(t)
+	r, err := NewReaderWithOptions(bytes.NewReader(data), int64(len(data)), ReaderOptions{MaxTotalSize: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := r.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+	rb, err := r.Open("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rb.Close()
+	if _, err := io.ReadAll(ra); err != nil {
+		t.Fatal(err)
+	}
+	var lerr *LimitError
+	if _, err := io.ReadAll(rb); !errors.As(err, &lerr) || lerr.Limit != "MaxTotalSize" {
+		t.Errorf("got error %v, want a MaxTotalSize limit error", err)
+	}
+}
+
+func TestReaderOptionsEntries(t *testing.T) {
+	data := limitsTestZip(t)
+	_, err := NewReaderWithOptions(bytes.NewReader(data), int64(len(data)), ReaderOptions{MaxEntries: 2})
+	var lerr *LimitError
+	if !errors.As(err, &lerr) || lerr.Limit != "MaxEntries" {
+		t.Errorf("got error %v, want a MaxEntries limit error", err)
+	}
+	if _, err := NewReaderWithOptions(bytes.NewReader(data), int64(len(data)), ReaderOptions{MaxEntries: 3}); err != nil {
+		t.Error(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenReaderWithOptions(name, ReaderOptions{MaxEntries: 2})
+	if !errors.As(err, &lerr) || lerr.Limit != "MaxEntries" {
+		t.Errorf("got error %v, want a MaxEntries limit error", err)
+	}
+	rc, err := OpenReaderWithOptions(name, ReaderOptions{MaxEntries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+}
+
+func TestReaderOptionsOverlap(t *testing.T) {
+	// Point the directory record of "a" to the local file header of "zeros",
+	// or inside its data, or make the data of "b" run into the directory.
+	data := limitsTestZip(t)
+	dirOffset := int(binary.LittleEndian.Uint32(data[len(data)-6:]))
+	recordLen := func(offset int) int {
+		b := data[offset:]
+		return directoryHeaderLen + int(binary.LittleEndian.Uint16(b[28:])) +
+			int(binary.LittleEndian.Uint16(b[30:])) + int(binary.LittleEndian.Uint16(b[32:]))
+	}
+	a := dirOffset + recordLen(dirOffset)
+	b := a + recordLen(a)
+	tests := []struct {
+		desc   string
+		offset int // offset of the field to patch
+		value  uint32
+		other  string
+	}{
+		{"header of zeros", a + 42, 0, "zeros"},
+		{"data of zeros", a + 42, 50, "zeros"},
+		{"directory", b + 20, uint32(dirOffset) - binary.LittleEndian.Uint32(data[b+42:]), ""},
+	}
+	for _, tt := range tests {
+		buf := bytes.Clone(data)
+		binary.LittleEndian.PutUint32(buf[tt.offset:], tt.value)
+		if _, err := NewReader(bytes.NewReader(buf), int64(len(buf))); err != nil {
+			t.Fatalf("%s: NewReader: %v", tt.desc, err)
+		}
+		_, err := NewReaderWithOptions(bytes.NewReader(buf), int64(len(buf)), ReaderOptions{})
+		var oerr *OverlapError
+		if !errors.As(err, &oerr) || !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: got error %v, want an overlap error", tt.desc, err)
+		} else if oerr.Other != tt.other {
+			t.Errorf("%s: got error %v, want an overlap with %q", tt.desc, err, tt.other)
+		}
+	}
+}
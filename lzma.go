@@ -0,0 +1,85 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// LZMA files start with a header of the LZMA version and the length of the
+// LZMA properties, followed by the properties, see section 5.8 of the ZIP
+// specification. Unlike the classic LZMA format, the uncompressed size is
+// not stored in the stream, and bit 1 of the general purpose flags is set if
+// the stream ends with an end of stream marker.
+const (
+	lzmaHeaderLen        = 4
+	lzmaPropsLen         = 5 // properties byte and dictionary size
+	lzmaClassicHeaderLen = 13
+	lzmaVersionMajor     = 9
+	lzmaVersionMinor     = 20
+	lzmaEOSFlag          = 0x2
+)
+
+func newLZMAWriter(w io.Writer) (io.WriteCloser, error) {
+	return lzma.WriterConfig{EOSMarker: true}.NewWriter(&lzmaHeaderWriter{w: w})
+}
+
+func newLZMAReader(r io.Reader) io.ReadCloser {
+	return newLZMASizeReader(r, -1)
+}
+
+// newLZMASizeReader returns a reader of the LZMA stream r, which stops at the
+// uncompressed size if it is not negative. The size is required to read the
+// streams without the end of stream marker.
+func newLZMASizeReader(r io.Reader, size int64) io.ReadCloser {
+	return &lazyReader{r: r, init: func(r io.Reader) (io.Reader, error) {
+		var buf [lzmaHeaderLen + lzmaClassicHeaderLen]byte
+		if _, err := io.ReadFull(r, buf[:lzmaHeaderLen+lzmaPropsLen]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if binary.LittleEndian.Uint16(buf[2:]) != lzmaPropsLen {
+			return nil, errors.New("zip: invalid LZMA properties")
+		}
+		// The classic header is the properties followed by the size, which
+		// is all ones if it is unknown.
+		binary.LittleEndian.PutUint64(buf[lzmaHeaderLen+lzmaPropsLen:], uint64(size))
+		header := bytes.NewReader(buf[lzmaHeaderLen:])
+		return lzma.NewReader(io.MultiReader(header, r))
+	}}
+}
+
+// lzmaHeaderWriter replaces the header of the classic LZMA format, which
+// stores the uncompressed size, with the LZMA header of the ZIP format.
+type lzmaHeaderWriter struct {
+	w      io.Writer
+	header []byte
+}
+
+func (w *lzmaHeaderWriter) Write(p []byte) (int, error) {
+	var n int
+	if len(w.header) < lzmaClassicHeaderLen {
+		n = min(len(p), lzmaClassicHeaderLen-len(w.header))
+		w.header = append(w.header, p[:n]...)
+		if len(w.header) < lzmaClassicHeaderLen {
+			return n, nil
+		}
+		var buf [lzmaHeaderLen + lzmaPropsLen]byte
+		b := writeBuf(buf[:])
+		b.uint8(lzmaVersionMajor)
+		b.uint8(lzmaVersionMinor)
+		b.uint16(lzmaPropsLen)
+		copy(b, w.header[:lzmaPropsLen])
+		if _, err := w.w.Write(buf[:]); err != nil {
+			return 0, err
+		}
+		p = p[n:]
+	}
+	m, err := w.w.Write(p)
+	return n + m, err
+}
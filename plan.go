@@ -0,0 +1,378 @@
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// PlanOp is the kind of operation recorded by a [Plan].
+type PlanOp int
+
+const (
+	// PlanAppend adds a new file to the end of the zip archive.
+	PlanAppend PlanOp = iota
+	// PlanOverwrite removes the existing file and writes the new file data.
+	PlanOverwrite
+	// PlanDelete removes the existing files with the name.
+	PlanDelete
+)
+
+func (op PlanOp) String() string {
+	switch op {
+	case PlanAppend:
+		return "append"
+	case PlanOverwrite:
+		return "overwrite"
+	case PlanDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("PlanOp(%d)", int(op))
+}
+
+// PlanOperation is an operation recorded by a [Plan].
+type PlanOperation struct {
+	Op   PlanOp
+	Name string
+	// Size is the number of bytes written for the new file, including the
+	// local file header and the data descriptor. For PlanDelete, it is the
+	// number of bytes of the removed files.
+	Size int64
+
+	fh   *FileHeader
+	mode AppendMode
+	open func() (io.ReadCloser, error)
+}
+
+// A Plan records the appends, overwrites and deletes to make to the zip
+// archive of an [Updater] without writing anything, and reports the outcome
+// of the update. The plan is created by [Updater.Plan], and the recorded
+// operations are written by [Plan.Apply].
+//
+// The outcome is estimated in the way the Updater writes the files: the data
+// after a removed file is moved to fill the gap, and the new files are written
+// at the end of the file data. If [WithSpaceReuse] is enabled, no data is
+// moved and Size is the upper bound of the final size.
+type Plan struct {
+	// Operations are the recorded operations in order.
+	Operations []PlanOperation
+	// ShiftBytes is the number of bytes of existing data moved to fill the
+	// space of the removed files.
+	ShiftBytes int64
+	// Size is the size of the zip archive after the plan is applied and the
+	// Updater is closed.
+	Size int64
+	// Zip64 are the names of the files which require the zip64 format after
+	// the update, because their sizes or header offsets exceed 4GiB.
+	Zip64 []string
+	// Conflicts are the problems found in the recorded operations, such as
+	// the insecure names rejected by [filepath.IsLocal] and the deleted
+	// names which do not exist. Each conflict is an [*fs.PathError]. A plan
+	// with conflicts can not be applied.
+	Conflicts []error
+
+	u       *Updater
+	applied bool
+	entries []*planEntry
+	// dataEnd and dirOffset are the simulated write offset and directory
+	// offset of the Updater, see Updater.dirOffset.
+	dataEnd   int64
+	dirOffset int64
+	// fileSize is the simulated size of the file before writing the
+	// directory record.
+	fileSize int64
+}
+
+// planEntry is a file of the zip archive simulated by a Plan.
+type planEntry struct {
+	name   string
+	offset int64
+	span   int64 // local file header, file data and data descriptor
+	// dirLen is the length of the directory header without zip64 extra.
+	dirLen int64
+	// large reports whether the file sizes require the zip64 format.
+	large bool
+	// wasZip64 reports whether the file required the zip64 format before
+	// the update.
+	wasZip64 bool
+}
+
+func (e *planEntry) zip64(baseOffset int64) bool {
+	return e.large || e.offset-baseOffset >= uint32max
+}
+
+// Plan returns an empty [Plan] for the zip archive being updated. The
+// Updater must not be modified until the plan is applied or discarded.
+func (u *Updater) Plan() (*Plan, error) {
+	if u.closed {
+		return nil, errors.New("zip: plan closed updater")
+	}
+	if u.last != nil && !u.last.closed {
+		return nil, errors.New("zip: plan while writing a file")
+	}
+	cursor, err := u.rw.offset()
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{
+		u:         u,
+		dataEnd:   cursor,
+		dirOffset: u.dirOffset,
+		fileSize:  u.rw.size,
+	}
+	for _, h := range u.dir {
+		end, err := u.fileEnd(h)
+		if err != nil {
+			return nil, fmt.Errorf("zip: file %q: %w", h.Name, err)
+		}
+		p.entries = append(p.entries, &planEntry{
+			name:     h.Name,
+			offset:   int64(h.offset),
+			span:     end - int64(h.offset),
+			dirLen:   int64(directoryHeaderLen + len(h.Name) + len(stripExtra(h.Extra, zip64ExtraID)) + len(h.Comment)),
+			large:    h.isZip64(),
+			wasZip64: h.isZip64() || int64(h.offset)-u.baseOffset >= uint32max,
+		})
+	}
+	p.update()
+	return p, nil
+}
+
+// Append records adding a file to the zip archive in the same way as
+// [Updater.AppendHeader]. The content of the file is read from the reader
+// returned by open, which is called twice: once by Append to compress the
+// content and measure its size, and again by [Plan.Apply] to write it. Both
+// readers must return the same content, or the plan does not match the
+// result. The open may be nil for directories.
+//
+// The Plan takes ownership of fh, the caller must not modify it.
+func (p *Plan) Append(fh *FileHeader, mode AppendMode, open func() (io.ReadCloser, error)) error {
+	if p.applied {
+		return errors.New("zip: plan already applied")
+	}
+	op := PlanOperation{
+		Op:   PlanAppend,
+		Name: fh.Name,
+		fh:   fh,
+		mode: mode,
+		open: open,
+	}
+	if insecurePath(fh.Name) {
+		p.Conflicts = append(p.Conflicts, &fs.PathError{Op: "append", Path: fh.Name, Err: ErrInsecurePath})
+	}
+
+	e := &planEntry{name: fh.Name}
+	isDir := strings.HasSuffix(fh.Name, "/")
+	var encExtraLen, encDataLen int
+	if !isDir {
+		encExtraLen, encDataLen = encryptionOverhead(fh.encryption)
+	}
+	extraLen := len(fh.Extra) + encExtraLen
+	if !fh.Modified.IsZero() {
+		extraLen += len(extTimeExtra(fh.Modified))
+	}
+	e.dirLen = int64(directoryHeaderLen + len(fh.Name) + extraLen + len(fh.Comment))
+	e.span = int64(fileHeaderLen + len(fh.Name) + extraLen)
+	if !isDir {
+		compressed, uncompressed, err := p.measure(fh.Method, open)
+		if err != nil {
+			return err
+		}
+		compressed += int64(encDataLen)
+		e.large = compressed >= uint32max || uncompressed >= uint32max
+		e.span += compressed
+		if e.large {
+			e.span += dataDescriptor64Len
+		} else {
+			e.span += dataDescriptorLen
+		}
+	}
+	op.Size = e.span
+
+	offset := p.dirOffset
+	if p.u.reuseSpace {
+		offset = p.dataEnd
+	}
+	if mode == APPEND_MODE_OVERWRITE {
+		if i := slices.IndexFunc(p.entries, func(e *planEntry) bool { return e.name == fh.Name }); i >= 0 {
+			op.Op = PlanOverwrite
+			p.remove(i)
+			if !p.u.reuseSpace {
+				// The new file is written after the moved data, see
+				// Updater.removeFile.
+				offset = p.dirOffset
+			}
+		}
+	}
+	e.offset = offset
+	p.entries = append(p.entries, e)
+	p.dataEnd = offset + e.span
+	p.dirOffset = max(p.dirOffset, p.dataEnd)
+	p.fileSize = max(p.fileSize, p.dataEnd)
+	p.Operations = append(p.Operations, op)
+	p.update()
+	return nil
+}
+
+// Delete records removing the files with the given names from the zip
+// archive in the same way as [Updater.Delete]. The names which do not exist
+// are reported as conflicts.
+func (p *Plan) Delete(names ...string) {
+	for _, name := range names {
+		op := PlanOperation{Op: PlanDelete, Name: name}
+		found := false
+		for i := len(p.entries) - 1; i >= 0; i-- {
+			if p.entries[i].name != name {
+				continue
+			}
+			found = true
+			start, end := p.remove(i)
+			op.Size += end - start
+			// With the free space reused, the data is not moved and the
+			// next file is still written after the last one.
+			if !p.u.reuseSpace && start < p.dataEnd {
+				p.dataEnd -= end - start
+			}
+		}
+		if !found {
+			p.Conflicts = append(p.Conflicts, &fs.PathError{Op: "delete", Path: name, Err: fs.ErrNotExist})
+			continue
+		}
+		p.Operations = append(p.Operations, op)
+	}
+	p.update()
+}
+
+// remove removes the simulated file and moves the data after it unless the
+// free space is reused. It returns the range of the removed file.
+func (p *Plan) remove(i int) (start, end int64) {
+	e := p.entries[i]
+	start, end = e.offset, e.offset+e.span
+	if p.u.reuseSpace {
+		p.entries = slices.Delete(p.entries, i, i+1)
+		return start, end
+	}
+	// The space up to the next file is removed, see Updater.removeFile.
+	end = p.dirOffset
+	if i < len(p.entries)-1 {
+		end = p.entries[i+1].offset
+	}
+	p.entries = slices.Delete(p.entries, i, i+1)
+	p.ShiftBytes += p.dirOffset - end
+	for _, e := range p.entries[i:] {
+		e.offset -= end - start
+	}
+	p.dirOffset -= end - start
+	return start, end
+}
+
+// measure returns the compressed and uncompressed size of the content read
+// from open.
+func (p *Plan) measure(method uint16, open func() (io.ReadCloser, error)) (int64, int64, error) {
+	comp := p.u.compressor(method)
+	if comp == nil {
+		return 0, 0, ErrAlgorithm
+	}
+	if open == nil {
+		return 0, 0, errors.New("zip: plan: no content to append")
+	}
+	rc, err := open()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rc.Close()
+	cw := &countWriter{w: io.Discard}
+	w, err := comp(cw)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, 0, err
+	}
+	return cw.count, n, nil
+}
+
+// update computes the final size and the zip64 files of the zip archive.
+func (p *Plan) update() {
+	// The directory record is written after the file data if the zip
+	// archive can be truncated, see Updater.Close.
+	_, truncate := p.u.rw.f.(truncater)
+	start := p.dirOffset
+	if truncate {
+		start = p.dataEnd
+	}
+
+	p.Zip64 = p.Zip64[:0]
+	var dirLen int64
+	if p.u.preDir != nil {
+		// The block is written before the directory record.
+		start += p.u.preDir.Size
+	}
+	for _, e := range p.entries {
+		dirLen += e.dirLen
+		if e.zip64(p.u.baseOffset) {
+			dirLen += 28 // zip64 extra block
+			if !e.wasZip64 {
+				p.Zip64 = append(p.Zip64, e.name)
+			}
+		}
+	}
+	if len(p.entries) >= uint16max || dirLen >= uint32max || start-p.u.baseOffset >= uint32max {
+		dirLen += directory64EndLen + directory64LocLen
+	}
+	dirLen += directoryEndLen + int64(len(p.u.comment))
+
+	p.Size = start + dirLen
+	if !truncate {
+		p.Size = max(p.Size, p.fileSize)
+	}
+}
+
+// Apply writes the recorded operations to the zip archive. The directory
+// record is written by [Updater.Close] as usual. Apply refuses to apply a plan
+// with conflicts.
+func (p *Plan) Apply() error {
+	if p.applied {
+		return errors.New("zip: plan already applied")
+	}
+	if len(p.Conflicts) > 0 {
+		return fmt.Errorf("zip: plan has conflicts: %w", errors.Join(p.Conflicts...))
+	}
+	p.applied = true
+	for _, op := range p.Operations {
+		if op.Op == PlanDelete {
+			if err := p.u.Delete(op.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.apply(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Plan) apply(op PlanOperation) error {
+	w, err := p.u.AppendHeader(op.fh, op.mode)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(op.fh.Name, "/") {
+		return nil
+	}
+	rc, err := op.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,183 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// planAppend records appending the file of wt to the plan.
+func planAppend(t *testing.T, p *Plan, wt *WriteTest, mode AppendMode) {
+	t.Helper()
+	fh := &FileHeader{
+		Name:   wt.Name,
+		Method: wt.Method,
+	}
+	if wt.Mode != 0 {
+		fh.SetMode(wt.Mode)
+	}
+	err := p.Append(fh, mode, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(wt.Data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdaterPlan(t *testing.T) {
+	for _, truncate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("truncate=%v", truncate), func(t *testing.T) {
+			f := createTestZip(t, overwriteTestsOriginal)
+			original := readTestFileData(t, f)
+			var rws io.ReadWriteSeeker = f
+			if !truncate {
+				rws = struct{ io.ReadWriteSeeker }{f}
+			}
+			u, err := NewUpdater(rws)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := u.Plan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Size != int64(len(original)) {
+				t.Errorf("got size %d of empty plan, want %d", p.Size, len(original))
+			}
+			planAppend(t, p, &overwriteTestsReplaced[1], APPEND_MODE_OVERWRITE)
+			p.Delete("setuid")
+			newFile := WriteTest{Name: "new", Data: []byte(strings.Repeat("new data", 100)), Method: Deflate, Mode: 0666}
+			planAppend(t, p, &newFile, APPEND_MODE_KEEP_ORIGINAL)
+			planAppend(t, p, &WriteTest{Name: "dir/", Mode: fs.ModeDir | 0755}, APPEND_MODE_OVERWRITE)
+
+			if got := readTestFileData(t, f); !bytes.Equal(got, original) {
+				t.Fatal("zip archive is modified by the plan")
+			}
+			want := []PlanOp{PlanOverwrite, PlanDelete, PlanAppend, PlanAppend}
+			if len(p.Operations) != len(want) {
+				t.Fatalf("got %d operations, want %d", len(p.Operations), len(want))
+			}
+			for i, op := range p.Operations {
+				if op.Op != want[i] {
+					t.Errorf("operation %d: got %v, want %v", i, op.Op, want[i])
+				}
+			}
+			if p.ShiftBytes <= 0 {
+				t.Errorf("got %d bytes shifted, want > 0", p.ShiftBytes)
+			}
+			if len(p.Conflicts) != 0 {
+				t.Errorf("got conflicts %v", p.Conflicts)
+			}
+			if len(p.Zip64) != 0 {
+				t.Errorf("got zip64 files %q", p.Zip64)
+			}
+
+			if err := p.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			if err := u.Close(); err != nil {
+				t.Fatal(err)
+			}
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != p.Size {
+				t.Errorf("got size %d, planned %d", size, p.Size)
+			}
+			r := openTestZip(t, f)
+			tests := []WriteTest{
+				overwriteTestsOriginal[0],
+				overwriteTestsOriginal[2],
+				overwriteTestsOriginal[4],
+				overwriteTestsOriginal[5],
+				overwriteTestsOriginal[6],
+				overwriteTestsOriginal[7],
+				overwriteTestsReplaced[1],
+				newFile,
+			}
+			if len(r.File) != len(tests)+1 {
+				t.Fatalf("got %d files, want %d", len(r.File), len(tests)+1)
+			}
+			for i, wt := range tests {
+				testReadFile(t, r.File[i], &wt)
+			}
+		})
+	}
+}
+
+func TestUpdaterPlanSpaceReuse(t *testing.T) {
+	// The removed files are kept as free space, and the new files may be
+	// written into it, so the planned size is the upper bound.
+	for _, truncate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("truncate=%v", truncate), func(t *testing.T) {
+			f := createTestZip(t, overwriteTestsOriginal)
+			var rws io.ReadWriteSeeker = f
+			if !truncate {
+				rws = struct{ io.ReadWriteSeeker }{f}
+			}
+			u, err := NewUpdater(rws, WithSpaceReuse())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := u.Plan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Delete("setuid")
+			newFile := WriteTest{Name: "new", Data: []byte(strings.Repeat("new data", 100)), Method: Deflate, Mode: 0666}
+			planAppend(t, p, &newFile, APPEND_MODE_KEEP_ORIGINAL)
+			if err := p.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			if err := u.Close(); err != nil {
+				t.Fatal(err)
+			}
+			size, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > p.Size {
+				t.Errorf("got size %d, planned at most %d", size, p.Size)
+			}
+			r := openTestZip(t, f)
+			if len(r.File) != len(overwriteTestsOriginal) {
+				t.Errorf("got %d files, want %d", len(r.File), len(overwriteTestsOriginal))
+			}
+		})
+	}
+}
+
+func TestUpdaterPlanConflicts(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	original := readTestFileData(t, f)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := u.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	planAppend(t, p, &WriteTest{Name: "../evil", Data: []byte("evil")}, APPEND_MODE_OVERWRITE)
+	p.Delete("foo", "not-exist")
+	if len(p.Conflicts) != 2 {
+		t.Fatalf("got conflicts %v, want 2", p.Conflicts)
+	}
+	if !errors.Is(p.Conflicts[0], ErrInsecurePath) {
+		t.Errorf("got conflict %v, want %v", p.Conflicts[0], ErrInsecurePath)
+	}
+	if !errors.Is(p.Conflicts[1], fs.ErrNotExist) {
+		t.Errorf("got conflict %v, want %v", p.Conflicts[1], fs.ErrNotExist)
+	}
+	if err := p.Apply(); err == nil {
+		t.Error("Apply: expected error for conflicts")
+	}
+	if got := readTestFileData(t, f); !bytes.Equal(got, original) {
+		t.Error("zip archive is modified by the plan")
+	}
+}
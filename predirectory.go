@@ -0,0 +1,156 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// apkSigBlockMagic is the magic at the end of the APK Signing Block.
+const apkSigBlockMagic = "APK Sig Block 42"
+
+// maxPreDirectoryBlockSize is the size of the largest block before the
+// directory record that the Updater keeps in memory. APK Signing Blocks are
+// far smaller.
+const maxPreDirectoryBlockSize = 64 << 20
+
+// apkSigBlockMinLen is the length of an empty APK Signing Block: the size of
+// the block at its start and end, and the magic.
+const apkSigBlockMinLen = 8 + 8 + len(apkSigBlockMagic)
+
+// A PreDirectoryBlock is the data stored between the last file and the
+// central directory of a zip archive, which does not belong to any file.
+// Android APKs and some signed JARs store the APK Signing Block there.
+type PreDirectoryBlock struct {
+	// Offset is the offset of the block in the zip archive it is read from.
+	Offset int64
+	// Size is the size of the block in bytes.
+	Size int64
+	// APKSigningBlock reports whether the block is an APK Signing Block,
+	// which ends with the magic "APK Sig Block 42".
+	APKSigningBlock bool
+
+	r io.ReaderAt // content of the block, starting at 0
+}
+
+// Open returns a reader of the content of the block.
+func (b *PreDirectoryBlock) Open() *io.SectionReader {
+	return io.NewSectionReader(b.r, 0, b.Size)
+}
+
+// PreDirectoryBlock returns the data stored between the last file and the
+// central directory of the zip archive, or nil if there is none. The zeros
+// used to pad the directory record are not reported.
+func (r *Reader) PreDirectoryBlock() (*PreDirectoryBlock, error) {
+	if r.preDir != nil {
+		return r.preDir, nil
+	}
+	dataEnd := r.baseOffset
+	var last *File
+	for _, f := range r.File {
+		if last == nil || f.headerOffset > last.headerOffset {
+			last = f
+		}
+	}
+	if last != nil {
+		end, err := fileDataEnd(r.r, &last.FileHeader, last.headerOffset)
+		if err != nil {
+			return nil, err
+		}
+		dataEnd = end
+	}
+	return findPreDirectoryBlock(r.r, dataEnd, r.dirOffset)
+}
+
+// findPreDirectoryBlock returns the block stored between the end of the file
+// data and the directory record at dirOffset in r. An APK Signing Block is
+// detected by its magic, any other data is reported unless it is all zeros.
+func findPreDirectoryBlock(r io.ReaderAt, dataEnd, dirOffset int64) (*PreDirectoryBlock, error) {
+	if dataEnd >= dirOffset {
+		return nil, nil
+	}
+	if dirOffset-dataEnd >= int64(apkSigBlockMinLen) {
+		// The block starts and ends with its size, excluding the size
+		// at its start.
+		var buf [8 + len(apkSigBlockMagic)]byte
+		if _, err := r.ReadAt(buf[:], dirOffset-int64(len(buf))); err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint64(buf[:])
+		if string(buf[8:]) == apkSigBlockMagic && size >= uint64(len(buf)) && size <= uint64(dirOffset-dataEnd-8) {
+			start := dirOffset - int64(size) - 8
+			if _, err := r.ReadAt(buf[:8], start); err != nil {
+				return nil, err
+			}
+			if binary.LittleEndian.Uint64(buf[:]) == size {
+				return &PreDirectoryBlock{
+					Offset:          start,
+					Size:            dirOffset - start,
+					APKSigningBlock: true,
+					r:               io.NewSectionReader(r, start, dirOffset-start),
+				}, nil
+			}
+		}
+	}
+
+	sr := io.NewSectionReader(r, dataEnd, dirOffset-dataEnd)
+	buf := make([]byte, min(bufferSize, dirOffset-dataEnd))
+	for {
+		n, err := sr.Read(buf)
+		if len(bytes.Trim(buf[:n], "\x00")) > 0 {
+			return &PreDirectoryBlock{
+				Offset: dataEnd,
+				Size:   dirOffset - dataEnd,
+				r:      sr,
+			}, nil
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// initPreDirectoryBlock finds the block between the last file and the
+// directory record, which is kept in memory unless it is dropped. New files
+// are written over the block, so a block larger than maxPreDirectoryBlockSize
+// must be dropped.
+func (u *Updater) initPreDirectoryBlock() error {
+	dataEnd := u.prefixLen
+	if len(u.dir) > 0 {
+		end, err := u.fileEnd(u.dir[len(u.dir)-1])
+		if err != nil {
+			return err
+		}
+		dataEnd = end
+	}
+	b, err := findPreDirectoryBlock(u.rw, dataEnd, u.dirOffset)
+	if b == nil || err != nil {
+		return err
+	}
+	u.dirOffset = b.Offset
+	if u.dropPreDir {
+		return nil
+	}
+	if b.Size > maxPreDirectoryBlockSize {
+		return fmt.Errorf("zip: block of %d bytes before the central directory is too large to keep, see WithDropPreDirectoryBlock", b.Size)
+	}
+	data := make([]byte, b.Size)
+	if _, err := io.ReadFull(b.Open(), data); err != nil {
+		return err
+	}
+	b.r = bytes.NewReader(data)
+	u.preDir = b
+	return nil
+}
+
+// PreDirectoryBlock returns the data written between the last file and the
+// central directory of the zip archive, or nil if there is none or it is
+// dropped, see [WithDropPreDirectoryBlock]. The Offset of the block is its
+// offset in the original zip archive.
+func (u *Updater) PreDirectoryBlock() *PreDirectoryBlock {
+	return u.preDir
+}
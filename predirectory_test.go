@@ -0,0 +1,155 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// apkSigningBlock returns an APK Signing Block with a single ID-value pair.
+func apkSigningBlock(value string) []byte {
+	pairLen := 4 + len(value)
+	size := 8 + pairLen + 8 + len(apkSigBlockMagic)
+	b := binary.LittleEndian.AppendUint64(nil, uint64(size))
+	b = binary.LittleEndian.AppendUint64(b, uint64(pairLen))
+	b = binary.LittleEndian.AppendUint32(b, 0x7109871a) // APK Signature Scheme v2
+	b = append(b, value...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(size))
+	return append(b, apkSigBlockMagic...)
+}
+
+// createTestZipBlock returns a zip archive of the tests with the block stored
+// before the directory record.
+func createTestZipBlock(t *testing.T, tests []WriteTest, block []byte) *os.File {
+	t.Helper()
+	f := createTestZip(t, tests)
+	data := readTestFileData(t, f)
+	end := len(data) - directoryEndLen
+	dirOffset := binary.LittleEndian.Uint32(data[end+16:])
+	binary.LittleEndian.PutUint32(data[end+16:], dirOffset+uint32(len(block)))
+	data = append(data[:dirOffset], append(block, data[dirOffset:]...)...)
+	if _, err := f.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func testPreDirectoryBlock(t *testing.T, r *Reader, block []byte, apk bool) {
+	t.Helper()
+	b, err := r.PreDirectoryBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil {
+		if b != nil {
+			t.Fatalf("got block of %d bytes at %d, want none", b.Size, b.Offset)
+		}
+		return
+	}
+	if b == nil {
+		t.Fatal("block not found")
+	}
+	if b.APKSigningBlock != apk {
+		t.Errorf("got APKSigningBlock %v, want %v", b.APKSigningBlock, apk)
+	}
+	data, err := io.ReadAll(b.Open())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, block) {
+		t.Errorf("got block %q, want %q", data, block)
+	}
+	if b.Offset+b.Size != r.dirOffset {
+		t.Errorf("block ends at %d, want directory offset %d", b.Offset+b.Size, r.dirOffset)
+	}
+}
+
+func TestReaderPreDirectoryBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []byte
+		want  []byte
+		apk   bool
+	}{
+		{"apk", apkSigningBlock("signature"), apkSigningBlock("signature"), true},
+		{"padded-apk", append(make([]byte, 10), apkSigningBlock("signature")...), apkSigningBlock("signature"), true},
+		{"unknown", []byte("unknown data"), []byte("unknown data"), false},
+		{"zeros", make([]byte, 100), nil, false},
+		{"none", nil, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := createTestZipBlock(t, writeTests, test.block)
+			testPreDirectoryBlock(t, openTestZip(t, f), test.want, test.apk)
+		})
+	}
+}
+
+func TestUpdaterPreDirectoryBlock(t *testing.T) {
+	block := apkSigningBlock("signature")
+	for _, truncate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("truncate=%v", truncate), func(t *testing.T) {
+			f := createTestZipBlock(t, overwriteTestsOriginal, block)
+			var rws io.ReadWriteSeeker = f
+			if !truncate {
+				rws = struct{ io.ReadWriteSeeker }{f}
+			}
+			u, err := NewUpdater(rws)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := u.PreDirectoryBlock(); b == nil || !b.APKSigningBlock {
+				t.Fatalf("got block %+v, want APK Signing Block", b)
+			}
+			if err := u.Delete("setuid"); err != nil {
+				t.Fatal(err)
+			}
+			testAppend(t, u, &overwriteTestsReplaced[1], APPEND_MODE_OVERWRITE)
+			if err := u.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r := openTestZip(t, f)
+			testPreDirectoryBlock(t, r, block, true)
+			testReadFile(t, r.File[len(r.File)-1], &overwriteTestsReplaced[1])
+		})
+	}
+}
+
+func TestUpdaterDropPreDirectoryBlock(t *testing.T) {
+	f := createTestZipBlock(t, overwriteTestsOriginal, []byte("unknown data"))
+	u, err := NewUpdater(f, WithDropPreDirectoryBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := u.PreDirectoryBlock(); b != nil {
+		t.Errorf("got block %+v, want nil", b)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	testPreDirectoryBlock(t, r, nil, false)
+	if len(r.File) != len(overwriteTestsOriginal) {
+		t.Errorf("got %d files, want %d", len(r.File), len(overwriteTestsOriginal))
+	}
+}
+
+func TestUpdaterLargePreDirectoryBlock(t *testing.T) {
+	block := bytes.Repeat([]byte("x"), maxPreDirectoryBlockSize+1)
+	f := createTestZipBlock(t, overwriteTestsOriginal, block)
+	if _, err := NewUpdater(f); err == nil {
+		t.Fatal("NewUpdater succeeded with a block too large to keep")
+	}
+	u, err := NewUpdater(f, WithDropPreDirectoryBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	testPreDirectoryBlock(t, r, nil, false)
+}
@@ -28,6 +28,7 @@ var (
 	ErrAlgorithm    = errors.New("zip: unsupported compression algorithm")
 	ErrChecksum     = errors.New("zip: checksum error")
 	ErrInsecurePath = errors.New("zip: insecure file path")
+	ErrPassword     = errors.New("zip: invalid password")
 )
 
 // A Reader serves content from a ZIP archive.
@@ -36,10 +37,21 @@ type Reader struct {
 	File          []*File
 	Comment       string
 	decompressors map[uint16]Decompressor
+	passwordFunc  func(f *File) (string, error)
 
 	// Some JAR files are zip files with a prefix that is a bash script.
 	// The baseOffset field is the start of the zip file proper.
 	baseOffset int64
+	// dirOffset is the offset of the directory record, including baseOffset.
+	dirOffset int64
+	// preDir is the block before the directory record held by an Updater,
+	// see Reader.PreDirectoryBlock. The dirOffset of the Updater view is
+	// zero, since the block is not stored in the zip archive being updated.
+	preDir *PreDirectoryBlock
+	// limits is set by NewReaderWithOptions, see ReaderOptions.
+	limits *readerLimits
+	// size is the size of the archive, including the data before baseOffset.
+	size int64
 
 	// fileList is a list of files sorted by ename,
 	// for use by the Open method.
@@ -74,6 +86,10 @@ type File struct {
 // Programs that want to accept non-local names can ignore
 // the ErrInsecurePath error and use the returned reader.
 func OpenReader(name string) (*ReadCloser, error) {
+	return openReader(name, nil)
+}
+
+func openReader(name string, limits *readerLimits) (*ReadCloser, error) {
 	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
@@ -84,6 +100,7 @@ func OpenReader(name string) (*ReadCloser, error) {
 		return nil, err
 	}
 	r := new(ReadCloser)
+	r.limits = limits
 	if err = r.init(f, fi.Size()); err != nil && err != ErrInsecurePath {
 		f.Close()
 		return nil, err
@@ -103,10 +120,14 @@ func OpenReader(name string) (*ReadCloser, error) {
 // Programs that want to accept non-local names can ignore
 // the [ErrInsecurePath] error and use the returned reader.
 func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	return newReader(r, size, nil)
+}
+
+func newReader(r io.ReaderAt, size int64, limits *readerLimits) (*Reader, error) {
 	if size < 0 {
 		return nil, errors.New("zip: size cannot be negative")
 	}
-	zr := new(Reader)
+	zr := &Reader{limits: limits}
 	var err error
 	if err = zr.init(r, size); err != nil && err != ErrInsecurePath {
 		return nil, err
@@ -120,19 +141,27 @@ func (r *Reader) init(rdr io.ReaderAt, size int64) error {
 		return err
 	}
 	r.r = rdr
+	r.size = size
 	r.baseOffset = baseOffset
+	r.dirOffset = baseOffset + int64(end.directoryOffset)
 	// Since the number of directory records is not validated, it is not
 	// safe to preallocate r.File without first checking that the specified
 	// number of files is reasonable, since a malformed archive may
 	// indicate it contains up to 1 << 128 - 1 files. Since each file has a
 	// header which will be _at least_ 30 bytes we can safely preallocate
 	// if (data size / 30) >= end.directoryRecords.
+	if r.limits != nil {
+		// The count may be truncated, but not larger than the actual one.
+		if err := r.limits.checkEntries(end.directoryRecords); err != nil {
+			return err
+		}
+	}
 	if end.directorySize < uint64(size) && (uint64(size)-end.directorySize)/30 >= end.directoryRecords {
 		r.File = make([]*File, 0, end.directoryRecords)
 	}
 	r.Comment = end.comment
 	rs := io.NewSectionReader(rdr, 0, size)
-	if _, err = rs.Seek(r.baseOffset+int64(end.directoryOffset), io.SeekStart); err != nil {
+	if _, err = rs.Seek(r.dirOffset, io.SeekStart); err != nil {
 		return err
 	}
 	buf := bufio.NewReader(rs)
@@ -152,21 +181,25 @@ func (r *Reader) init(rdr io.ReaderAt, size int64) error {
 		}
 		f.headerOffset += r.baseOffset
 		r.File = append(r.File, f)
+		if r.limits != nil {
+			if err := r.limits.checkEntries(uint64(len(r.File))); err != nil {
+				return err
+			}
+		}
 	}
 	if uint16(len(r.File)) != uint16(end.directoryRecords) { // only compare 16 bits here
 		// Return the readDirectoryHeader error if we read
 		// the wrong number of directory entries.
 		return err
 	}
+	if r.limits != nil {
+		if err := r.checkOverlap(); err != nil {
+			return err
+		}
+	}
 	if os.Getenv("GODEBUG") == "zipinsecurepath=0" {
 		for _, f := range r.File {
-			if f.Name == "" {
-				// Zip permits an empty file name field.
-				continue
-			}
-			// The zip specification states that names must use forward slashes,
-			// so consider any backslashes in the name insecure.
-			if !filepath.IsLocal(f.Name) || strings.Contains(f.Name, "\\") {
+			if insecurePath(f.Name) {
 				// zipinsecurepath.IncNonDefault()
 				return ErrInsecurePath
 			}
@@ -175,6 +208,18 @@ func (r *Reader) init(rdr io.ReaderAt, size int64) error {
 	return nil
 }
 
+// insecurePath reports whether name is not local, as defined by
+// [filepath.IsLocal], or contains backslashes. The zip specification states
+// that names must use forward slashes, so consider any backslashes in the name
+// insecure. Zip permits an empty file name field, which is not insecure; the
+// callers which cannot store such a file skip it.
+func insecurePath(name string) bool {
+	if name == "" {
+		return false
+	}
+	return !filepath.IsLocal(name) || strings.Contains(name, "\\")
+}
+
 // RegisterDecompressor registers or overrides a custom decompressor for a
 // specific method ID. If a decompressor for a given method is not found,
 // [Reader] will default to looking up the decompressor at the package level.
@@ -185,6 +230,13 @@ func (r *Reader) RegisterDecompressor(method uint16, dcomp Decompressor) {
 	r.decompressors[method] = dcomp
 }
 
+// SetPasswordFunc sets the function returning the password of the encrypted
+// files opened by [File.Open] and [Reader.Open]. The error returned by fn is
+// returned by Open.
+func (r *Reader) SetPasswordFunc(fn func(f *File) (string, error)) {
+	r.passwordFunc = fn
+}
+
 func (r *Reader) decompressor(method uint16) Decompressor {
 	dcomp := r.decompressors[method]
 	if dcomp == nil {
@@ -213,7 +265,36 @@ func (f *File) DataOffset() (offset int64, err error) {
 
 // Open returns a [ReadCloser] that provides access to the [File]'s contents.
 // Multiple files may be read concurrently.
+//
+// The password of an encrypted file is returned by the function set by
+// [Reader.SetPasswordFunc]. If there is none, Open returns [ErrPassword].
 func (f *File) Open() (io.ReadCloser, error) {
+	var password func() (string, error)
+	if fn := f.zip.passwordFunc; fn != nil {
+		password = func() (string, error) { return fn(f) }
+	}
+	return f.open(password)
+}
+
+// OpenWithPassword is like [File.Open] but decrypts the content of the
+// encrypted file with the password. It returns [ErrPassword] if the password
+// is incorrect. The file must be encrypted by the WinZip AES encryption or the
+// traditional PKWARE encryption (ZipCrypto). A file which is not encrypted is
+// opened as usual.
+//
+// The traditional PKWARE encryption checks the password by a single byte, an
+// incorrect password may be detected only by [ErrChecksum] after reading the
+// content.
+func (f *File) OpenWithPassword(password string) (io.ReadCloser, error) {
+	return f.open(func() (string, error) { return password, nil })
+}
+
+func (f *File) open(password func() (string, error)) (io.ReadCloser, error) {
+	if l := f.zip.limits; l != nil {
+		if err := l.checkOpen(f); err != nil {
+			return nil, err
+		}
+	}
 	bodyOffset, err := f.findBodyOffset()
 	if err != nil {
 		return nil, err
@@ -236,22 +317,60 @@ func (f *File) Open() (io.ReadCloser, error) {
 	}
 	size := int64(f.CompressedSize64)
 	r := io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset, size)
-	dcomp := f.zip.decompressor(f.Method)
+	cr := &checksumReader{
+		hash: crc32.NewIEEE(),
+		f:    f,
+	}
+	var data io.Reader = r
+	method := f.Method
+	if f.IsEncrypted() {
+		if f.Flags&0x40 != 0 {
+			// The strong encryption is not supported.
+			return nil, ErrAlgorithm
+		}
+		if password == nil {
+			return nil, ErrPassword
+		}
+		pw, err := password()
+		if err != nil {
+			return nil, err
+		}
+		if f.Method == winZipAES {
+			version, enc, m, ok := f.aesExtra()
+			if !ok {
+				return nil, ErrFormat
+			}
+			ar, err := newAESReader(r, enc, pw)
+			if err != nil {
+				return nil, err
+			}
+			data, method = ar, m
+			cr.verify = ar.verify
+			// The CRC-32 checksum is not stored in the AE-2 format.
+			cr.nocrc = version == aesVendorVersion2
+		} else {
+			data, err = newZipCryptoReader(r, pw, f.zipCryptoCheck())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	dcomp := f.zip.decompressor(method)
 	if dcomp == nil {
 		return nil, ErrAlgorithm
 	}
-	var rc io.ReadCloser = dcomp(r)
-	var desr io.Reader
-	if f.hasDataDescriptor() {
-		desr = io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset+size, dataDescriptorLen)
+	if method == LZMA && f.Flags&lzmaEOSFlag == 0 && f.zip.decompressors[LZMA] == nil && isBuiltinDecompressor(LZMA) {
+		// The built-in LZMA decoder needs the size to read the stream
+		// without the end of stream marker.
+		dcomp = func(r io.Reader) io.ReadCloser {
+			return newLZMASizeReader(r, int64(f.UncompressedSize64))
+		}
 	}
-	rc = &checksumReader{
-		rc:   rc,
-		hash: crc32.NewIEEE(),
-		f:    f,
-		desr: desr,
+	cr.rc = dcomp(data)
+	if f.hasDataDescriptor() {
+		cr.desr = io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset+size, dataDescriptorLen)
 	}
-	return rc, nil
+	return cr, nil
 }
 
 // OpenRaw returns a [Reader] that provides access to the [File]'s contents without
@@ -278,12 +397,14 @@ func (r *dirReader) Close() error {
 }
 
 type checksumReader struct {
-	rc    io.ReadCloser
-	hash  hash.Hash32
-	nread uint64 // number of bytes read so far
-	f     *File
-	desr  io.Reader // if non-nil, where to read the data descriptor
-	err   error     // sticky error
+	rc     io.ReadCloser
+	hash   hash.Hash32
+	nread  uint64 // number of bytes read so far
+	f      *File
+	desr   io.Reader    // if non-nil, where to read the data descriptor
+	verify func() error // if non-nil, authenticates the encrypted data
+	nocrc  bool         // the CRC-32 checksum is not stored
+	err    error        // sticky error
 }
 
 func (r *checksumReader) Stat() (fs.FileInfo, error) {
@@ -300,6 +421,12 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 	if r.nread > r.f.UncompressedSize64 {
 		return 0, ErrFormat
 	}
+	if l := r.f.zip.limits; l != nil {
+		if err := l.read(n); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
 	if err == nil {
 		return
 	}
@@ -307,6 +434,12 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 		if r.nread != r.f.UncompressedSize64 {
 			return 0, io.ErrUnexpectedEOF
 		}
+		if r.verify != nil {
+			if err1 := r.verify(); err1 != nil {
+				r.err = err1
+				return n, err1
+			}
+		}
 		if r.desr != nil {
 			if err1 := readDataDescriptor(r.desr, r.f); err1 != nil {
 				if err1 == io.EOF {
@@ -314,7 +447,7 @@ func (r *checksumReader) Read(b []byte) (n int, err error) {
 				} else {
 					err = err1
 				}
-			} else if r.hash.Sum32() != r.f.CRC32 {
+			} else if !r.nocrc && r.hash.Sum32() != r.f.CRC32 {
 				err = ErrChecksum
 			}
 		} else {
@@ -349,6 +482,48 @@ func (f *File) findBodyOffset() (int64, error) {
 	return int64(fileHeaderLen + filenameLen + extraLen), nil
 }
 
+// fileDataEnd returns the end offset of the file fh whose local file header is
+// at offset in r, including the local file header, the file data and the data
+// descriptor.
+func fileDataEnd(r io.ReaderAt, fh *FileHeader, offset int64) (int64, error) {
+	var buf [fileHeaderLen]byte
+	if _, err := r.ReadAt(buf[:], offset); err != nil {
+		return 0, err
+	}
+	b := readBuf(buf[:])
+	if sig := b.uint32(); sig != fileHeaderSignature {
+		return 0, ErrFormat
+	}
+	b = b[22:] // skip over most of the header
+	filenameLen := int64(b.uint16())
+	extraLen := int64(b.uint16())
+	end := offset + fileHeaderLen + filenameLen + extraLen + int64(fh.CompressedSize64)
+	if !fh.hasDataDescriptor() {
+		return end, nil
+	}
+
+	// The signature of the data descriptor is optional, and the sizes are
+	// 8 bytes in zip64 format.
+	var desc [dataDescriptor64Len]byte
+	n, err := r.ReadAt(desc[:], end)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	var sig int64
+	if n >= 4 && binary.LittleEndian.Uint32(desc[:]) == dataDescriptorSignature {
+		sig = 4
+	}
+	if int64(n) < sig+12 {
+		return 0, ErrFormat
+	}
+	d := desc[sig+4 : n] // skip over the crc32
+	if len(d) >= 16 && binary.LittleEndian.Uint64(d) == fh.CompressedSize64 &&
+		binary.LittleEndian.Uint64(d[8:]) == fh.UncompressedSize64 {
+		return end + sig + 20, nil
+	}
+	return end + sig + 12, nil
+}
+
 // readDirectoryHeader attempts to read a directory header from r.
 // It returns io.ErrUnexpectedEOF if it cannot read a complete header,
 // and ErrFormat if it doesn't find a valid header signature.
@@ -386,6 +561,14 @@ func readDirectoryHeader(f *File, r io.Reader) error {
 	f.Extra = d[filenameLen : filenameLen+extraLen]
 	f.Comment = string(d[filenameLen+extraLen:])
 
+	return f.readExtra()
+}
+
+// readExtra determines the character encoding of the name and the comment,
+// and reads the zip64 sizes and offset and the modified time from the extra
+// fields of f, which has been read from a directory header or a local file
+// header.
+func (f *File) readExtra() error {
 	// Determine the character encoding.
 	utf8Valid1, utf8Require1 := detectUTF8(f.Name)
 	utf8Valid2, utf8Require2 := detectUTF8(f.Comment)
@@ -524,6 +707,39 @@ parseExtras:
 	return nil
 }
 
+// readLocalFileHeader attempts to read a local file header from r.
+// It returns io.ErrUnexpectedEOF if it cannot read a complete header,
+// and ErrFormat if it doesn't find a valid header signature.
+func readLocalFileHeader(f *File, r io.Reader) error {
+	var buf [fileHeaderLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	b := readBuf(buf[:])
+	if sig := b.uint32(); sig != fileHeaderSignature {
+		return ErrFormat
+	}
+	f.ReaderVersion = b.uint16()
+	f.Flags = b.uint16()
+	f.Method = b.uint16()
+	f.ModifiedTime = b.uint16()
+	f.ModifiedDate = b.uint16()
+	f.CRC32 = b.uint32()
+	f.CompressedSize = b.uint32()
+	f.UncompressedSize = b.uint32()
+	f.CompressedSize64 = uint64(f.CompressedSize)
+	f.UncompressedSize64 = uint64(f.UncompressedSize)
+	filenameLen := int(b.uint16())
+	extraLen := int(b.uint16())
+	d := make([]byte, filenameLen+extraLen)
+	if _, err := io.ReadFull(r, d); err != nil {
+		return err
+	}
+	f.Name = string(d[:filenameLen])
+	f.Extra = d[filenameLen:]
+	return f.readExtra()
+}
+
 func readDataDescriptor(r io.Reader, f *File) error {
 	var buf [dataDescriptorLen]byte
 	// The spec says: "Although not originally assigned a
@@ -601,6 +817,7 @@ func readDirectoryEnd(r io.ReaderAt, size int64) (dir *directoryEnd, baseOffset
 		return nil, 0, errors.New("zip: invalid comment length")
 	}
 	d.comment = string(b[:l])
+	d.endOffset = directoryEndOffset + directoryEndLen + int64(l)
 
 	// These values mean that the file can be a zip64 file
 	if d.directoryRecords == 0xffff || d.directorySize == 0xffff || d.directoryOffset == 0xffffffff {
@@ -0,0 +1,226 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NewReaderRecover returns a new [Reader] of the files found by scanning r for
+// local file headers, for the archives whose central directory is missing or
+// corrupt, such as truncated ones.
+//
+// The sizes and the CRC-32 checksum of a file are read from its local file
+// header or data descriptor, and its content is decompressed to verify them.
+// The files which cannot be verified, since they are encrypted or compressed
+// by an unsupported method, are kept. The fields only stored in the central
+// directory, such as ExternalAttrs and Comment, are set from the directory
+// records which are found after the files.
+//
+// The damaged regions of r are skipped. NewReaderRecover returns the reader
+// along with an error joining the errors of the damaged files, each of them
+// wrapping the reason, such as [ErrChecksum] or [io.ErrUnexpectedEOF].
+func NewReaderRecover(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < 0 {
+		return nil, errors.New("zip: size cannot be negative")
+	}
+	zr := &Reader{r: r}
+	s := &signatureScanner{r: r, size: size}
+	var errs []error
+	var end int64 // end of the last recovered file
+	for off := int64(0); ; {
+		var err error
+		off, err = s.find(off, fileHeaderSignature)
+		if err != nil {
+			return nil, err
+		}
+		if off < 0 {
+			break
+		}
+		f, fend, err := zr.recoverFile(s, off)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zip: damaged file at offset %d: %w", off, err))
+			off += 4
+			continue
+		}
+		zr.File = append(zr.File, f)
+		off, end = fend, fend
+	}
+	if err := zr.recoverDirectory(s, end); err != nil {
+		return nil, err
+	}
+	if d, _, err := readDirectoryEnd(r, size); err == nil {
+		zr.Comment = d.comment
+	}
+	return zr, errors.Join(errs...)
+}
+
+// recoverFile reads the file whose local file header is at offset off, and
+// verifies its content. It returns the end offset of the file data and the
+// data descriptor.
+func (r *Reader) recoverFile(s *signatureScanner, off int64) (*File, int64, error) {
+	f := &File{zip: r, zipr: r.r, headerOffset: off}
+	sr := io.NewSectionReader(r.r, off, s.size-off)
+	if err := readLocalFileHeader(f, sr); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	bodyOffset, _ := sr.Seek(0, io.SeekCurrent)
+	start := off + bodyOffset
+	if f.hasDataDescriptor() && f.CompressedSize64 == 0 {
+		csize, err := s.findDataDescriptor(start, f.Method == Store)
+		if err != nil {
+			return nil, 0, err
+		}
+		f.CompressedSize64 = uint64(csize)
+	}
+	end := start + int64(f.CompressedSize64)
+	if f.CompressedSize64 > uint64(s.size) || end > s.size {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if f.hasDataDescriptor() {
+		var buf [4 + dataDescriptor64Len]byte
+		n, err := r.r.ReadAt(buf[:], end)
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		crc, usize, n, ok := parseDataDescriptor(buf[:n], f.CompressedSize64, false)
+		if !ok {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		f.CRC32, f.UncompressedSize64 = crc, usize
+		end += int64(n)
+	}
+	f.CompressedSize = uint32(min(f.CompressedSize64, uint32max))
+	f.UncompressedSize = uint32(min(f.UncompressedSize64, uint32max))
+
+	rc, err := f.Open()
+	switch err {
+	case nil:
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+	case ErrPassword, ErrAlgorithm:
+		// The content cannot be verified.
+	default:
+		return nil, 0, err
+	}
+	return f, end, nil
+}
+
+// recoverDirectory sets the fields only stored in the central directory from
+// the directory records found from offset off.
+func (r *Reader) recoverDirectory(s *signatureScanner, off int64) error {
+	files := make(map[int64]*File, len(r.File))
+	for _, f := range r.File {
+		files[f.headerOffset] = f
+	}
+	for {
+		var err error
+		off, err = s.find(off, directoryHeaderSignature)
+		if err != nil {
+			return err
+		}
+		if off < 0 {
+			return nil
+		}
+		sr := io.NewSectionReader(r.r, off, s.size-off)
+		d := &File{}
+		if err := readDirectoryHeader(d, sr); err != nil {
+			off += 4
+			continue
+		}
+		if f := files[d.headerOffset]; f != nil && f.Name == d.Name {
+			f.CreatorVersion = d.CreatorVersion
+			f.ExternalAttrs = d.ExternalAttrs
+			f.Comment = d.Comment
+		}
+		n, _ := sr.Seek(0, io.SeekCurrent)
+		off += n
+	}
+}
+
+// Repair writes the files recovered by [NewReaderRecover] from r to w as a
+// new ZIP archive with a fresh central directory. The file data are copied
+// without being recompressed. After the archive is written, Repair returns
+// the error of NewReaderRecover reporting the damaged files, if any.
+func Repair(r io.ReaderAt, size int64, w io.Writer) error {
+	zr, rerr := NewReaderRecover(r, size)
+	if zr == nil {
+		return rerr
+	}
+	zw := NewWriter(w)
+	if err := zw.SetComment(zr.Comment); err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err := zw.Copy(f); err != nil {
+			return fmt.Errorf("zip: file %q: %w", f.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return rerr
+}
+
+// signatureScanner finds the signatures of the records in r.
+type signatureScanner struct {
+	r    io.ReaderAt
+	size int64
+	buf  []byte
+}
+
+// find returns the offset of the first signature sig from offset off, or -1
+// if it is not found.
+func (s *signatureScanner) find(off int64, sig uint32) (int64, error) {
+	if s.buf == nil {
+		s.buf = make([]byte, 64<<10)
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], sig)
+	for off+4 <= s.size {
+		n := int(min(int64(len(s.buf)), s.size-off))
+		if _, err := s.r.ReadAt(s.buf[:n], off); err != nil && err != io.EOF {
+			return -1, err
+		}
+		if i := bytes.Index(s.buf[:n], b[:]); i >= 0 {
+			return off + int64(i), nil
+		}
+		// The signature may start in the last 3 bytes.
+		off += int64(n) - 3
+	}
+	return -1, nil
+}
+
+// findDataDescriptor returns the size of the file data from offset start up
+// to its data descriptor, which is found by its signature followed by the
+// compressed size, see descriptorScanner.
+func (s *signatureScanner) findDataDescriptor(start int64, stored bool) (int64, error) {
+	var buf [4 + dataDescriptor64Len + 4]byte
+	for off := start; ; off++ {
+		var err error
+		off, err = s.find(off, dataDescriptorSignature)
+		if err != nil {
+			return 0, err
+		}
+		if off < 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n, err := s.r.ReadAt(buf[:], off)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		csize := uint64(off - start)
+		_, usize, _, ok := parseDataDescriptor(buf[:n], csize, true)
+		if ok && (!stored || usize == csize) {
+			return off - start, nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"testing"
+)
+
+// recoverTestZip returns an archive of stored and deflated files, whose sizes
+// are stored in the data descriptors, and the contents of the files.
+func recoverTestZip(t *testing.T) ([]byte, map[string]string) {
+	t.Helper()
+	tests := []WriteTest{
+		{Name: "stored", Data: bytes.Repeat([]byte("stored data "), 100), Method: Store, Mode: 0750},
+		{Name: "deflated", Data: bytes.Repeat([]byte("deflated data "), 1000), Method: Deflate, Mode: 0750},
+		{Name: "empty", Method: Deflate, Mode: 0750},
+		{Name: "last", Data: bytes.Repeat([]byte("the last file "), 100), Method: Deflate, Mode: 0750},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	want := make(map[string]string)
+	for _, wt := range tests {
+		testCreate(t, w, &wt)
+		want[wt.Name] = string(wt.Data)
+	}
+	if err := w.SetComment("comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), want
+}
+
+func TestNewReaderRecover(t *testing.T) {
+	data, want := recoverTestZip(t)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := r.File[len(r.File)-1]
+	lastOffset := last.headerOffset
+
+	t.Run("intact", func(t *testing.T) {
+		r, err := NewReaderRecover(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestFiles(t, r); !maps.Equal(got, want) {
+			t.Errorf("got files %q, want %q", got, want)
+		}
+		if r.Comment != "comment" {
+			t.Errorf("got comment %q, want %q", r.Comment, "comment")
+		}
+		for _, f := range r.File {
+			if f.Mode() != 0750 {
+				t.Errorf("file %q: got mode %v, want %v", f.Name, f.Mode(), fs.FileMode(0750))
+			}
+		}
+	})
+
+	t.Run("no directory end", func(t *testing.T) {
+		data := data[:len(data)-directoryEndLen-len("comment")]
+		if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Fatal("NewReader: got no error")
+		}
+		r, err := NewReaderRecover(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestFiles(t, r); !maps.Equal(got, want) {
+			t.Errorf("got files %q, want %q", got, want)
+		}
+		// The directory records are found.
+		for _, f := range r.File {
+			if f.Mode() != 0750 {
+				t.Errorf("file %q: got mode %v, want %v", f.Name, f.Mode(), fs.FileMode(0750))
+			}
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data := data[:lastOffset+40]
+		r, err := NewReaderRecover(bytes.NewReader(data), int64(len(data)))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		want := maps.Clone(want)
+		delete(want, "last")
+		if got := readTestFiles(t, r); !maps.Equal(got, want) {
+			t.Errorf("got files %q, want %q", got, want)
+		}
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		data := bytes.Clone(data)
+		i := bytes.Index(data, []byte("stored data"))
+		data[i] ^= 1
+		r, err := NewReaderRecover(bytes.NewReader(data), int64(len(data)))
+		if !errors.Is(err, ErrChecksum) {
+			t.Errorf("got error %v, want %v", err, ErrChecksum)
+		}
+		want := maps.Clone(want)
+		delete(want, "stored")
+		if got := readTestFiles(t, r); !maps.Equal(got, want) {
+			t.Errorf("got files %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRepair(t *testing.T) {
+	data, want := recoverTestZip(t)
+	data = data[:len(data)-10]
+	var buf bytes.Buffer
+	if err := Repair(bytes.NewReader(data), int64(len(data)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFiles(t, r); !maps.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	for _, f := range r.File {
+		if f.Mode() != 0750 {
+			t.Errorf("file %q: got mode %v, want %v", f.Name, f.Mode(), fs.FileMode(0750))
+		}
+	}
+}
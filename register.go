@@ -5,10 +5,15 @@
 package zip
 
 import (
+	"compress/bzip2"
 	"compress/flate"
 	"errors"
 	"io"
 	"sync"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 // A Compressor returns a new compressing writer, writing to w.
@@ -101,33 +106,154 @@ func (r *pooledFlateReader) Close() error {
 	return err
 }
 
+func newBzip2Writer(w io.Writer) (io.WriteCloser, error) {
+	return dsbzip2.NewWriter(w, &dsbzip2.WriterConfig{Level: dsbzip2.DefaultCompression})
+}
+
+func newBzip2Reader(r io.Reader) io.ReadCloser {
+	return io.NopCloser(bzip2.NewReader(r))
+}
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func newZstdReader(r io.Reader) io.ReadCloser {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	if err != nil {
+		return &errReader{err}
+	}
+	return zr.IOReadCloser()
+}
+
+func newXZWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func newXZReader(r io.Reader) io.ReadCloser {
+	return &lazyReader{r: r, init: func(r io.Reader) (io.Reader, error) {
+		return xz.NewReader(r)
+	}}
+}
+
+// lazyReader defers creating the decompressing reader until the first Read,
+// since a Decompressor cannot report the errors of reading the stream header.
+type lazyReader struct {
+	r    io.Reader
+	init func(r io.Reader) (io.Reader, error)
+	dr   io.Reader
+	err  error
+}
+
+func (r *lazyReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.dr == nil {
+		r.dr, r.err = r.init(r.r)
+		if r.err != nil {
+			return 0, r.err
+		}
+	}
+	n, err := r.dr.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *lazyReader) Close() error {
+	return nil
+}
+
+// errReader returns err from every Read.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+func (r *errReader) Close() error             { return nil }
+
 var (
-	compressors   sync.Map // map[uint16]Compressor
-	decompressors sync.Map // map[uint16]Decompressor
+	compressors   sync.Map   // map[uint16]Compressor or builtinCompressor
+	decompressors sync.Map   // map[uint16]Decompressor or builtinDecompressor
+	registerMu    sync.Mutex // guards the replacement of the built-in ones
+)
+
+// builtinCompressor and builtinDecompressor are the built-in compressors and
+// decompressors of the methods which are not built into archive/zip, which
+// the programs may have registered their own for before they were built in.
+// They are replaced by the registered ones instead of panicking.
+type (
+	builtinCompressor   Compressor
+	builtinDecompressor Decompressor
 )
 
 func init() {
 	compressors.Store(Store, Compressor(func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil }))
 	compressors.Store(Deflate, Compressor(func(w io.Writer) (io.WriteCloser, error) { return newFlateWriter(w), nil }))
+	compressors.Store(Bzip2, builtinCompressor(newBzip2Writer))
+	compressors.Store(LZMA, builtinCompressor(newLZMAWriter))
+	compressors.Store(Zstd, builtinCompressor(newZstdWriter))
+	compressors.Store(XZ, builtinCompressor(newXZWriter))
 
 	decompressors.Store(Store, Decompressor(io.NopCloser))
 	decompressors.Store(Deflate, Decompressor(newFlateReader))
+	decompressors.Store(Deflate64, builtinDecompressor(newDeflate64Reader))
+	decompressors.Store(Bzip2, builtinDecompressor(newBzip2Reader))
+	decompressors.Store(LZMA, builtinDecompressor(newLZMAReader))
+	decompressors.Store(Zstd, builtinDecompressor(newZstdReader))
+	decompressors.Store(XZ, builtinDecompressor(newXZReader))
 }
 
 // RegisterDecompressor allows custom decompressors for a specified method ID.
-// The common methods [Store] and [Deflate] are built in.
+// The methods [Store], [Deflate], [Deflate64], [Bzip2], [LZMA], [Zstd] and [XZ] are built in.
+//
+// RegisterDecompressor panics if the method is already registered, except
+// that the built-in decompressors other than the ones of Store and Deflate
+// are replaced, so that the programs registering their own keep using them.
 func RegisterDecompressor(method uint16, dcomp Decompressor) {
-	if _, dup := decompressors.LoadOrStore(method, dcomp); dup {
-		panic("decompressor already registered")
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	if di, dup := decompressors.Load(method); dup {
+		if _, ok := di.(builtinDecompressor); !ok {
+			panic("decompressor already registered")
+		}
 	}
+	decompressors.Store(method, dcomp)
 }
 
 // RegisterCompressor registers custom compressors for a specified method ID.
-// The common methods [Store] and [Deflate] are built in.
+// The methods [Store], [Deflate], [Bzip2], [LZMA], [Zstd] and [XZ] are built in.
+//
+// RegisterCompressor panics if the method is already registered, except that
+// the built-in compressors other than the ones of Store and Deflate are
+// replaced, so that the programs registering their own keep using them.
 func RegisterCompressor(method uint16, comp Compressor) {
-	if _, dup := compressors.LoadOrStore(method, comp); dup {
-		panic("compressor already registered")
+	registerMu.Lock()
+	defer registerMu.Unlock()
+	if ci, dup := compressors.Load(method); dup {
+		if _, ok := ci.(builtinCompressor); !ok {
+			panic("compressor already registered")
+		}
 	}
+	compressors.Store(method, comp)
+}
+
+// isBuiltinCompressor reports whether the registered compressor of the method
+// is the built-in one.
+func isBuiltinCompressor(method uint16) bool {
+	ci, _ := compressors.Load(method)
+	_, ok := ci.(builtinCompressor)
+	return ok
+}
+
+// isBuiltinDecompressor reports whether the registered decompressor of the
+// method is the built-in one.
+func isBuiltinDecompressor(method uint16) bool {
+	di, _ := decompressors.Load(method)
+	_, ok := di.(builtinDecompressor)
+	return ok
 }
 
 func compressor(method uint16) Compressor {
@@ -135,6 +261,9 @@ func compressor(method uint16) Compressor {
 	if !ok {
 		return nil
 	}
+	if comp, ok := ci.(builtinCompressor); ok {
+		return Compressor(comp)
+	}
 	return ci.(Compressor)
 }
 
@@ -143,5 +272,8 @@ func decompressor(method uint16) Decompressor {
 	if !ok {
 		return nil
 	}
+	if dcomp, ok := di.(builtinDecompressor); ok {
+		return Decompressor(dcomp)
+	}
 	return di.(Decompressor)
 }
@@ -0,0 +1,305 @@
+package zip
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// methodTestFiles are the files stored in the fixtures of the compression
+// methods.
+var methodTestFiles = map[string]string{
+	"short.txt": "short\n",
+	"long.txt":  strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40) + "\n",
+}
+
+func testMethodFixture(t *testing.T, name string, method uint16) {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, zf := range r.File {
+		found = found || zf.Method == method
+	}
+	if !found {
+		t.Errorf("no file is compressed by method %d", method)
+	}
+	if got := readTestFiles(t, r); !maps.Equal(got, methodTestFiles) {
+		t.Errorf("got files %q, want %q", got, methodTestFiles)
+	}
+}
+
+// testMethodRoundTrip writes files compressed by the method with Writer and
+// Updater, and reads them back.
+func testMethodRoundTrip(t *testing.T, method, readerVersion uint16) {
+	t.Helper()
+	data := strings.Repeat("compressed data ", 1000)
+	want := map[string]string{
+		"writer":  data,
+		"empty":   "",
+		"updater": data,
+	}
+
+	f := createTestZip(t, nil)
+	if err := f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(f)
+	for _, name := range []string{"writer", "empty"} {
+		fw, err := w.CreateHeader(&FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, want[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uw, err := u.AppendHeader(&FileHeader{Name: "updater", Method: method}, APPEND_MODE_OVERWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(uw, want["updater"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	for _, zf := range r.File {
+		if zf.Method != method {
+			t.Errorf("file %q: got method %d, want %d", zf.Name, zf.Method, method)
+		}
+		if zf.ReaderVersion != readerVersion {
+			t.Errorf("file %q: got reader version %d, want %d", zf.Name, zf.ReaderVersion, readerVersion)
+		}
+		if zf.UncompressedSize64 > 0 && zf.CompressedSize64 >= zf.UncompressedSize64 {
+			t.Errorf("file %q: compressed size %d, want less than %d", zf.Name, zf.CompressedSize64, zf.UncompressedSize64)
+		}
+	}
+	if got := readTestFiles(t, r); !maps.Equal(got, want) {
+		t.Errorf("got %d files, want %d with the same contents", len(got), len(want))
+	}
+}
+
+func TestRegisterReplaceBuiltin(t *testing.T) {
+	// Restore the built-in compressor and decompressor of Zstd.
+	ci, _ := compressors.Load(Zstd)
+	di, _ := decompressors.Load(Zstd)
+	t.Cleanup(func() {
+		compressors.Store(Zstd, ci)
+		decompressors.Store(Zstd, di)
+	})
+
+	// Store the content instead of compressing it.
+	RegisterCompressor(Zstd, func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil })
+	RegisterDecompressor(Zstd, io.NopCloser)
+	content := strings.Repeat("not compressed ", 100)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	fw, err := w.CreateHeader(&FileHeader{Name: "file", Method: Zstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(fw, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(content)) {
+		t.Error("the registered compressor is not used")
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFiles(t, r)["file"]; got != content {
+		t.Errorf("got %q, want %q", got, content)
+	}
+
+	// The registered ones are not replaced, nor the ones of archive/zip.
+	for _, method := range []uint16{Zstd, Deflate} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("method %d registered twice without panicking", method)
+				}
+			}()
+			RegisterCompressor(method, func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil })
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("method %d registered twice without panicking", method)
+				}
+			}()
+			RegisterDecompressor(method, io.NopCloser)
+		}()
+	}
+}
+
+func TestReaderBzip2(t *testing.T) {
+	// Created by Info-ZIP zip -Z bzip2.
+	testMethodFixture(t, "testdata/bzip2.zip", Bzip2)
+}
+
+func TestBzip2RoundTrip(t *testing.T) {
+	testMethodRoundTrip(t, Bzip2, zipVersion46)
+}
+
+func TestReaderZstd(t *testing.T) {
+	// Not created by another zip tool: the files are compressed by the zstd
+	// command line tool v1.5.6 (zstd -19) and stored in headers written by a
+	// script, which checks the decompressor against another encoder, but not
+	// the archives of other tools. It should be replaced by archives written
+	// by 7-Zip and libzip.
+	testMethodFixture(t, "testdata/zstd.zip", Zstd)
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	testMethodRoundTrip(t, Zstd, zipVersion63)
+}
+
+func TestReaderXZ(t *testing.T) {
+	// Not created by another zip tool: the files are compressed by the xz
+	// command line tool of XZ Utils 5.6.4 (xz -9) and stored in headers
+	// written by a script, like the zstd fixture, which should be replaced
+	// likewise.
+	testMethodFixture(t, "testdata/xz.zip", XZ)
+}
+
+func TestXZRoundTrip(t *testing.T) {
+	testMethodRoundTrip(t, XZ, zipVersion63)
+}
+
+func TestReaderXZCorrupt(t *testing.T) {
+	rc := newXZReader(strings.NewReader("not an xz stream"))
+	defer rc.Close()
+	if _, err := io.ReadAll(rc); err == nil {
+		t.Error("got no error reading a corrupt xz stream")
+	}
+}
+
+func TestReaderLZMA(t *testing.T) {
+	// Created by Python's zipfile module, which writes the end of stream
+	// marker.
+	testMethodFixture(t, "testdata/lzma.zip", LZMA)
+}
+
+func TestLZMARoundTrip(t *testing.T) {
+	testMethodRoundTrip(t, LZMA, zipVersion63)
+}
+
+func TestLZMARegisteredCompressor(t *testing.T) {
+	// The end of stream marker flag is only set for the built-in compressor.
+	nop := func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil }
+	lzmaFlags := func(register func(w *Writer)) uint16 {
+		t.Helper()
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		register(w)
+		fw, err := w.CreateHeader(&FileHeader{Name: "file", Method: LZMA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, "content"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.File[0].Flags & lzmaEOSFlag
+	}
+	if flags := lzmaFlags(func(w *Writer) {}); flags == 0 {
+		t.Error("built-in compressor: end of stream marker flag not set")
+	}
+	if flags := lzmaFlags(func(w *Writer) { w.RegisterCompressor(LZMA, nop) }); flags != 0 {
+		t.Error("Writer.RegisterCompressor: end of stream marker flag set")
+	}
+
+	ci, _ := compressors.Load(LZMA)
+	t.Cleanup(func() { compressors.Store(LZMA, ci) })
+	RegisterCompressor(LZMA, nop)
+	if flags := lzmaFlags(func(w *Writer) {}); flags != 0 {
+		t.Error("RegisterCompressor: end of stream marker flag set")
+	}
+}
+
+func TestReaderLZMANoEOS(t *testing.T) {
+	// Without the end of stream marker, the stream ends at the uncompressed
+	// size.
+	content := strings.Repeat("no end of stream marker ", 100)
+	var stream bytes.Buffer
+	lw, err := lzma.WriterConfig{Size: int64(len(content))}.NewWriter(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(lw, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := stream.Bytes()
+	header := []byte{lzmaVersionMajor, lzmaVersionMinor, lzmaPropsLen, 0}
+	data = append(header, append(data[:lzmaPropsLen:lzmaPropsLen], data[lzmaClassicHeaderLen:]...)...)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	fw, err := w.CreateRaw(&FileHeader{
+		Name:               "file",
+		Method:             LZMA,
+		CRC32:              crc32.ChecksumIEEE([]byte(content)),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFiles(t, r)["file"]; got != content {
+		t.Errorf("got %q, want %q", got, content)
+	}
+}
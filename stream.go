@@ -0,0 +1,484 @@
+package zip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// streamBufferSize is the size of the buffer of StreamReader, which is also
+// the window to search for the data descriptor of a file of unknown size.
+const streamBufferSize = 64 << 10
+
+// A StreamReader reads a ZIP archive sequentially from an [io.Reader], such as
+// a pipe or an HTTP body, by walking the local file headers. Unlike [Reader],
+// it does not need the central directory at the end of the archive, so the
+// files are read before the whole archive is received.
+//
+// The sizes and the CRC-32 checksum of a file may be stored in the data
+// descriptor after the file data. If the local file header does not store the
+// compressed size, the data descriptor is found by its signature, which is
+// written by nearly all the tools, including [Writer].
+//
+// The files must be stored one after another from the start of the archive,
+// archives with a prefix or gaps between the files, such as the ones left by
+// [Updater], cannot be read. Encrypted files are not supported.
+type StreamReader struct {
+	r             *bufio.Reader
+	offset        int64 // offset of r in the archive
+	checkDir      bool
+	decompressors map[uint16]Decompressor
+	files         []*File // files read so far if checkDir is set
+	cur           *streamFile
+	err           error // sticky error of Next
+}
+
+// A StreamReaderOption configures a [StreamReader] created by
+// [NewStreamReader].
+type StreamReaderOption func(r *StreamReader)
+
+// WithDirectoryCheck makes [StreamReader.Next] read the central directory at
+// the end of the archive and compare it against the local file headers.
+// Next returns an error wrapping [ErrFormat] if a file is missing, or its
+// name, method, CRC-32 checksum or sizes do not match. The fields only stored
+// in the central directory, such as ExternalAttrs and Comment, are set in the
+// FileHeaders returned by Next.
+func WithDirectoryCheck() StreamReaderOption {
+	return func(r *StreamReader) {
+		r.checkDir = true
+	}
+}
+
+// NewStreamReader returns a new [StreamReader] reading from r.
+func NewStreamReader(r io.Reader, opts ...StreamReaderOption) *StreamReader {
+	sr := &StreamReader{r: bufio.NewReaderSize(r, streamBufferSize)}
+	for _, opt := range opts {
+		opt(sr)
+	}
+	return sr
+}
+
+// RegisterDecompressor registers or overrides a custom decompressor for a
+// specific method ID. If a decompressor for a given method is not found,
+// [StreamReader] will default to looking up the decompressor at the package
+// level.
+func (r *StreamReader) RegisterDecompressor(method uint16, dcomp Decompressor) {
+	if r.decompressors == nil {
+		r.decompressors = make(map[uint16]Decompressor)
+	}
+	r.decompressors[method] = dcomp
+}
+
+func (r *StreamReader) decompressor(method uint16) Decompressor {
+	dcomp := r.decompressors[method]
+	if dcomp == nil {
+		dcomp = decompressor(method)
+	}
+	return dcomp
+}
+
+// streamFile is the file being read by StreamReader.
+type streamFile struct {
+	f     *File
+	data  io.Reader // compressed data
+	scan  *descriptorScanner
+	rc    io.ReadCloser
+	hash  hash.Hash32
+	nread uint64
+	done  bool  // the file data and the data descriptor have been read
+	err   error // sticky error of Read
+}
+
+// Next advances to the next file in the archive, skipping the rest of the
+// current file. It returns [io.EOF] at the end of the archive.
+//
+// If the sizes and the CRC-32 checksum are stored in the data descriptor,
+// they are zero in the returned [FileHeader] until the file content has been
+// read to the end.
+func (r *StreamReader) Next() (*FileHeader, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.cur != nil {
+		if err := r.skip(); err != nil {
+			r.err = err
+			return nil, err
+		}
+		r.cur = nil
+	}
+	f, err := r.next()
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+	if r.checkDir {
+		r.files = append(r.files, f)
+	}
+	r.cur = &streamFile{f: f, hash: crc32.NewIEEE()}
+	if f.hasDataDescriptor() && f.CompressedSize64 == 0 {
+		r.cur.scan = &descriptorScanner{r: r, stored: f.Method == Store}
+		r.cur.data = r.cur.scan
+	} else {
+		r.cur.data = &countReader{r: r, n: int64(f.CompressedSize64)}
+	}
+	return &f.FileHeader, nil
+}
+
+// Read reads the content of the current file, and verifies its size and
+// CRC-32 checksum at the end. It returns [io.EOF] at the end of the file.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	cur := r.cur
+	if cur == nil {
+		return 0, io.EOF
+	}
+	if cur.err != nil {
+		return 0, cur.err
+	}
+	if cur.rc == nil {
+		if cur.f.IsEncrypted() {
+			cur.err = ErrAlgorithm
+			return 0, cur.err
+		}
+		dcomp := r.decompressor(cur.f.Method)
+		if dcomp == nil {
+			cur.err = ErrAlgorithm
+			return 0, cur.err
+		}
+		if cur.f.Method == LZMA && cur.f.Flags&lzmaEOSFlag == 0 && r.decompressors[LZMA] == nil && isBuiltinDecompressor(LZMA) {
+			size := int64(-1)
+			if !cur.f.hasDataDescriptor() {
+				size = int64(cur.f.UncompressedSize64)
+			}
+			dcomp = func(r io.Reader) io.ReadCloser {
+				return newLZMASizeReader(r, size)
+			}
+		}
+		cur.rc = dcomp(cur.data)
+	}
+	n, err := cur.rc.Read(p)
+	cur.hash.Write(p[:n])
+	cur.nread += uint64(n)
+	if err == io.EOF {
+		err = r.finish()
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	cur.err = err
+	return n, err
+}
+
+// finish reads the rest of the file data and the data descriptor after the
+// content of the current file has been read, and verifies the content.
+func (r *StreamReader) finish() error {
+	cur := r.cur
+	f := cur.f
+	cur.rc.Close()
+	if _, err := io.Copy(io.Discard, cur.data); err != nil {
+		return err
+	}
+	if err := r.readDataDescriptor(); err != nil {
+		return err
+	}
+	cur.done = true
+	if cur.nread != f.UncompressedSize64 {
+		return ErrFormat
+	}
+	if cur.hash.Sum32() != f.CRC32 {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// skip skips the rest of the current file. The file data can be skipped
+// after an error of decompressing the content.
+func (r *StreamReader) skip() error {
+	cur := r.cur
+	if cur.done {
+		return nil
+	}
+	if cur.rc != nil {
+		cur.rc.Close()
+	}
+	if _, err := io.Copy(io.Discard, cur.data); err != nil {
+		return err
+	}
+	return r.readDataDescriptor()
+}
+
+// readDataDescriptor reads the data descriptor after the file data of the
+// current file, and sets the sizes and the CRC-32 checksum of the file.
+func (r *StreamReader) readDataDescriptor() error {
+	f := r.cur.f
+	if !f.hasDataDescriptor() {
+		return nil
+	}
+	if s := r.cur.scan; s != nil {
+		f.CRC32, f.CompressedSize64, f.UncompressedSize64 = s.crc32, s.n, s.usize
+		return nil
+	}
+	b, err := r.r.Peek(4 + dataDescriptor64Len)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	crc, usize, n, ok := parseDataDescriptor(b, f.CompressedSize64, false)
+	if !ok {
+		if len(b) < dataDescriptorLen {
+			return io.ErrUnexpectedEOF
+		}
+		return ErrFormat
+	}
+	f.CRC32, f.UncompressedSize64 = crc, usize
+	_, err = r.discard(n)
+	return err
+}
+
+func (r *StreamReader) discard(n int) (int, error) {
+	n, err := r.r.Discard(n)
+	r.offset += int64(n)
+	return n, err
+}
+
+// next reads the next local file header, or the central directory at the end
+// of the archive.
+func (r *StreamReader) next() (*File, error) {
+	for {
+		b, err := r.r.Peek(4)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch binary.LittleEndian.Uint32(b) {
+		case fileHeaderSignature:
+			return r.readFileHeader()
+		case dataDescriptorSignature:
+			// The archive may start with the signature of a spanned
+			// archive, which has a single segment.
+			if r.offset != 0 {
+				return nil, ErrFormat
+			}
+			if _, err := r.discard(4); err != nil {
+				return nil, err
+			}
+			continue
+		case directoryHeaderSignature, directory64EndSignature, directoryEndSignature:
+			if r.checkDir {
+				if err := r.checkDirectory(); err != nil {
+					return nil, err
+				}
+			}
+			return nil, io.EOF
+		}
+		return nil, ErrFormat
+	}
+}
+
+func (r *StreamReader) readFileHeader() (*File, error) {
+	offset := r.offset
+	f := &File{}
+	err := readLocalFileHeader(f, &countReader{r: r, n: -1})
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.headerOffset = offset
+	return f, nil
+}
+
+// checkDirectory reads the central directory and compares it against the
+// files read so far.
+func (r *StreamReader) checkDirectory() error {
+	files := make(map[int64]*File, len(r.files))
+	for _, f := range r.files {
+		files[f.headerOffset] = f
+	}
+	for {
+		b, err := r.r.Peek(4)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if binary.LittleEndian.Uint32(b) != directoryHeaderSignature {
+			break
+		}
+		d := &File{}
+		cr := &countReader{r: r, n: -1}
+		err = readDirectoryHeader(d, cr)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		f := files[d.headerOffset]
+		if f == nil {
+			return fmt.Errorf("zip: file %q has no local file header at %d: %w", d.Name, d.headerOffset, ErrFormat)
+		}
+		delete(files, d.headerOffset)
+		if f.Name != d.Name || f.Method != d.Method || f.CRC32 != d.CRC32 ||
+			f.CompressedSize64 != d.CompressedSize64 || f.UncompressedSize64 != d.UncompressedSize64 {
+			return fmt.Errorf("zip: file %q does not match the central directory: %w", f.Name, ErrFormat)
+		}
+		f.CreatorVersion = d.CreatorVersion
+		f.ExternalAttrs = d.ExternalAttrs
+		f.Comment = d.Comment
+	}
+	for _, f := range r.files {
+		if files[f.headerOffset] != nil {
+			return fmt.Errorf("zip: file %q is not in the central directory: %w", f.Name, ErrFormat)
+		}
+	}
+	return nil
+}
+
+// countReader reads from the StreamReader and counts the offset. If n is not
+// negative, it reads at most n bytes.
+type countReader struct {
+	r *StreamReader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	if c.n == 0 {
+		return 0, io.EOF
+	}
+	if c.n > 0 && int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.r.Read(p)
+	c.r.offset += int64(n)
+	if c.n > 0 {
+		c.n -= int64(n)
+	}
+	if err == io.EOF && c.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// descriptorScanner reads the file data of unknown size up to the data
+// descriptor, which is found by its signature followed by the compressed size
+// of the data read so far. For stored files, the uncompressed size must be
+// the same. The compressed size tells the data descriptor of the file apart
+// from the ones in the file data, e.g. of a stored ZIP archive.
+type descriptorScanner struct {
+	r      *StreamReader
+	stored bool
+	n      uint64 // bytes read so far
+	safe   int    // buffered bytes which are not the data descriptor
+	found  bool   // the data descriptor follows the safe bytes
+
+	// the data descriptor
+	crc32 uint32
+	usize uint64
+	size  int
+}
+
+func (s *descriptorScanner) Read(p []byte) (int, error) {
+	if s.safe == 0 {
+		if s.found {
+			if s.size > 0 {
+				_, err := s.r.discard(s.size)
+				s.size = 0
+				if err != nil {
+					return 0, err
+				}
+			}
+			return 0, io.EOF
+		}
+		if err := s.scan(); err != nil {
+			return 0, err
+		}
+		if s.safe == 0 {
+			return s.Read(p)
+		}
+	}
+	n, err := s.r.r.Read(p[:min(len(p), s.safe)])
+	s.r.offset += int64(n)
+	s.safe -= n
+	s.n += uint64(n)
+	return n, err
+}
+
+// scan finds the buffered bytes before the next possible data descriptor.
+func (s *descriptorScanner) scan() error {
+	b, err := s.r.r.Peek(s.r.r.Size())
+	if err != nil && err != io.EOF {
+		return err
+	}
+	eof := err == io.EOF
+	sig := []byte{0x50, 0x4b, 0x07, 0x08}
+	for i := 0; ; i++ {
+		j := bytes.Index(b[i:], sig)
+		if j < 0 {
+			// The signature may start in the last 3 bytes.
+			s.safe = len(b)
+			if !eof {
+				s.safe = max(i, len(b)-3)
+			}
+			break
+		}
+		i += j
+		if !eof && len(b)-i < 4+dataDescriptor64Len+4 {
+			// Wait for the whole data descriptor and the signature after it.
+			s.safe = i
+			break
+		}
+		csize := s.n + uint64(i)
+		crc, usize, n, ok := parseDataDescriptor(b[i:], csize, true)
+		if ok && (!s.stored || usize == csize) {
+			s.safe, s.found = i, true
+			s.crc32, s.usize, s.size = crc, usize, n
+			break
+		}
+	}
+	if s.safe == 0 && !s.found {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// parseDataDescriptor parses the data descriptor at the start of b for the
+// file data of csize bytes. The signature is optional unless sig is true. The
+// sizes are either 4 or 8 bytes, and the form whose compressed size is csize
+// is chosen. If both forms match, the one followed by a signature is chosen.
+// It returns the CRC-32 checksum, the uncompressed size and the length of the
+// data descriptor.
+func parseDataDescriptor(b []byte, csize uint64, sig bool) (crc uint32, usize uint64, n int, ok bool) {
+	var off int
+	if len(b) >= 4 && binary.LittleEndian.Uint32(b) == dataDescriptorSignature {
+		off = 4
+	} else if sig {
+		return 0, 0, 0, false
+	}
+	if len(b) < off+12 {
+		return 0, 0, 0, false
+	}
+	crc = binary.LittleEndian.Uint32(b[off:])
+	d := b[off+4:]
+	match32 := uint64(binary.LittleEndian.Uint32(d)) == csize
+	match64 := len(d) >= 16 && binary.LittleEndian.Uint64(d) == csize
+	if match32 && match64 {
+		// Prefer the form followed by the signature of a header.
+		next := b[off+12:]
+		match32 = len(next) >= 2 && next[0] == 'P' && next[1] == 'K'
+		match64 = !match32
+	}
+	switch {
+	case match32:
+		return crc, uint64(binary.LittleEndian.Uint32(d[4:])), off + 12, true
+	case match64:
+		return crc, binary.LittleEndian.Uint64(d[8:]), off + 20, true
+	}
+	return 0, 0, 0, false
+}
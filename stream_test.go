@@ -0,0 +1,252 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readStream reads all the files of the archive with StreamReader.
+func readStream(t *testing.T, r io.Reader, opts ...StreamReaderOption) (map[string]string, error) {
+	t.Helper()
+	sr := NewStreamReader(r, opts...)
+	files := make(map[string]string)
+	for {
+		fh, err := sr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		b, err := io.ReadAll(sr)
+		if err != nil {
+			return files, err
+		}
+		if fh.UncompressedSize64 != uint64(len(b)) {
+			t.Errorf("file %q: got size %d, want %d", fh.Name, fh.UncompressedSize64, len(b))
+		}
+		files[fh.Name] = string(b)
+	}
+}
+
+func TestStreamReaderTestdata(t *testing.T) {
+	for _, name := range []string{
+		"test.zip", "dd.zip", "go-with-datadesc-sig.zip", "winxp.zip", "unix.zip",
+		"symlink.zip", "utf8-7zip.zip", "utf8-infozip.zip", "utf8-osx.zip", "utf8-winrar.zip",
+		"time-7zip.zip", "time-infozip.zip", "time-osx.zip", "time-winrar.zip", "time-win7.zip",
+		"zip64.zip", "zip64-2.zip", "bzip2.zip", "lzma.zip", "xz.zip", "zstd.zip",
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := readTestFiles(t, r)
+			got, err := readStream(t, iotest.HalfReader(bytes.NewReader(data)), WithDirectoryCheck())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("got %d files, want %d with the same contents", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestStreamReaderNoDirectoryCheck(t *testing.T) {
+	// The headers are only kept for the directory check.
+	data, err := os.ReadFile("testdata/test.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := NewStreamReader(bytes.NewReader(data))
+	for {
+		_, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sr.files) != 0 {
+		t.Errorf("kept %d file headers without the directory check", len(sr.files))
+	}
+}
+
+func TestStreamReaderDataDescriptor(t *testing.T) {
+	// Writer stores the sizes and the CRC-32 checksum in the data descriptor.
+	// The stored file is a ZIP archive, whose data descriptors must not be
+	// taken as the one of the outer file.
+	var inner bytes.Buffer
+	iw := NewWriter(&inner)
+	for _, name := range []string{"a", "b"} {
+		fw, err := iw.CreateHeader(&FileHeader{Name: name, Method: Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, strings.Repeat(name, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := iw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"stored.zip": inner.String(),
+		"deflated":   strings.Repeat("deflated data ", 10000),
+		"empty":      "",
+		"dir/":       "",
+		"large":      strings.Repeat("0123456789", 20000),
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, name := range []string{"stored.zip", "deflated", "empty", "dir/", "large"} {
+		method := Deflate
+		if name == "stored.zip" || name == "large" {
+			method = Store
+		}
+		fw, err := w.CreateHeader(&FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, want[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []io.Reader{
+		bytes.NewReader(buf.Bytes()),
+		iotest.OneByteReader(bytes.NewReader(buf.Bytes())),
+	} {
+		got, err := readStream(t, r, WithDirectoryCheck())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(got, want) {
+			t.Errorf("got %d files, want %d with the same contents", len(got), len(want))
+		}
+	}
+
+	// Skip the files without reading them.
+	sr := NewStreamReader(bytes.NewReader(buf.Bytes()), WithDirectoryCheck())
+	var n int
+	for {
+		fh, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fh.Name == "deflated" {
+			// Read a part of the file.
+			if _, err := sr.Read(make([]byte, 10)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("got %d files, want %d", n, len(want))
+	}
+}
+
+func TestStreamReaderZip64DataDescriptor(t *testing.T) {
+	// A stored file of unknown size with the zip64 data descriptor, followed
+	// by an empty central directory.
+	content := []byte("zip64 data descriptor")
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, fileHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, zipVersion45)
+	b = binary.LittleEndian.AppendUint16(b, 0x8) // data descriptor
+	b = binary.LittleEndian.AppendUint16(b, Store)
+	b = append(b, make([]byte, 4+12)...) // time, CRC-32 and sizes
+	b = binary.LittleEndian.AppendUint16(b, 4)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = append(b, "file"...)
+	b = append(b, content...)
+	b = binary.LittleEndian.AppendUint32(b, dataDescriptorSignature)
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(content))
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(content)))
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(content)))
+	b = binary.LittleEndian.AppendUint32(b, directoryEndSignature)
+	b = append(b, make([]byte, directoryEndLen-4)...)
+
+	got, err := readStream(t, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["file"] != string(content) {
+		t.Errorf("got %q, want %q", got["file"], content)
+	}
+}
+
+func TestStreamReaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, name := range []string{"foo", "bar"} {
+		fw, err := w.CreateHeader(&FileHeader{Name: name, Method: Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, "content of "+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	dirOffset := int(binary.LittleEndian.Uint32(data[len(data)-6:]))
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(bytes.Clone(data))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"checksum", corrupt(func(b []byte) []byte {
+			i := bytes.Index(b, []byte("content of foo"))
+			b[i] ^= 1
+			return b
+		}), ErrChecksum},
+		{"truncated", data[:dirOffset/2], io.ErrUnexpectedEOF},
+		{"not zip", []byte("not a zip archive"), ErrFormat},
+		{"directory name", corrupt(func(b []byte) []byte {
+			i := bytes.LastIndex(b, []byte("bar"))
+			b[i] = 'c'
+			return b
+		}), ErrFormat},
+		{"missing directory record", corrupt(func(b []byte) []byte {
+			// Replace the first record of the directory with the end of
+			// the central directory.
+			end := b[len(b)-directoryEndLen:]
+			return append(b[:dirOffset], end...)
+		}), ErrFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readStream(t, bytes.NewReader(test.data), WithDirectoryCheck())
+			if !errors.Is(err, test.err) {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -29,8 +29,13 @@ import (
 
 // Compression methods.
 const (
-	Store   uint16 = 0 // no compression
-	Deflate uint16 = 8 // DEFLATE compressed
+	Store     uint16 = 0  // no compression
+	Deflate   uint16 = 8  // DEFLATE compressed
+	Deflate64 uint16 = 9  // Deflate64 compressed, only decompression is supported
+	Bzip2     uint16 = 12 // bzip2 compressed
+	LZMA      uint16 = 14 // LZMA compressed
+	Zstd      uint16 = 93 // Zstandard compressed
+	XZ        uint16 = 95 // XZ compressed
 )
 
 const (
@@ -58,6 +63,9 @@ const (
 	// Version numbers.
 	zipVersion20 = 20 // 2.0
 	zipVersion45 = 45 // 4.5 (reads and writes zip64 archives)
+	zipVersion46 = 46 // 4.6 (reads bzip2 compressed files)
+	zipVersion51 = 51 // 5.1 (reads AES encrypted files)
+	zipVersion63 = 63 // 6.3 (reads LZMA, Zstandard and XZ compressed files)
 
 	// Limits for non zip64 files.
 	uint16max = (1 << 16) - 1
@@ -77,6 +85,7 @@ const (
 	unixExtraID        = 0x000d // UNIX
 	extTimeExtraID     = 0x5455 // Extended timestamp
 	infoZipUnixExtraID = 0x5855 // Info-ZIP Unix extension
+	winZipAESExtraID   = 0x9901 // WinZip AES encryption
 )
 
 // FileHeader describes a file within a ZIP file.
@@ -158,6 +167,11 @@ type FileHeader struct {
 
 	Extra         []byte
 	ExternalAttrs uint32 // Meaning depends on CreatorVersion
+
+	// password and encryption are set by SetPassword to encrypt the file
+	// when writing.
+	password   string
+	encryption EncryptionMethod
 }
 
 // FileInfo returns an fs.FileInfo for the [FileHeader].
@@ -226,6 +240,7 @@ type directoryEnd struct {
 	directoryOffset    uint64 // relative to file
 	commentLen         uint16
 	comment            string
+	endOffset          int64 // offset of the end of the record, after the comment
 }
 
 // timeZone returns a *time.Location based on the provided offset.
@@ -346,6 +361,18 @@ func (h *FileHeader) hasDataDescriptor() bool {
 	return h.Flags&0x8 != 0
 }
 
+// readerVersion returns the version needed to extract a file compressed by
+// the method.
+func readerVersion(method uint16) uint16 {
+	switch method {
+	case Bzip2:
+		return zipVersion46
+	case LZMA, Zstd, XZ:
+		return zipVersion63
+	}
+	return zipVersion20
+}
+
 func msdosModeToFileMode(m uint32) (mode fs.FileMode) {
 	if m&msdosDir != 0 {
 		mode = fs.ModeDir | 0777
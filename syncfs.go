@@ -0,0 +1,154 @@
+package zip
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// AddFSOptions configures [Updater.AddFS] and [Updater.SyncFS].
+type AddFSOptions struct {
+	// Delete removes the files in the zip archive which do not exist in the
+	// file system.
+	Delete bool
+
+	// CompareCRC32 makes SyncFS compare the CRC-32 checksum of the file
+	// content in addition to the modification time and size, which detects
+	// the files changed without updating the modification time. If only the
+	// modification time differs, the file data is kept and only the
+	// modification time in the zip archive is updated.
+	CompareCRC32 bool
+}
+
+// AddFS adds the files from fs.FS to the zip archive. It walks the directory
+// tree starting at the root of the filesystem adding each file to the zip
+// using deflate while maintaining the directory structure. The existing files
+// with the same name are overwritten as [APPEND_MODE_OVERWRITE]. The opts may
+// be nil to use the default options.
+func (u *Updater) AddFS(fsys fs.FS, opts *AddFSOptions) error {
+	return u.addFS(fsys, opts, false)
+}
+
+// SyncFS synchronizes the zip archive with the files from fs.FS in the same
+// way as [Updater.AddFS], except that the existing files with the same
+// modification time and size are not overwritten, see
+// [AddFSOptions.CompareCRC32]. The existing directories are kept as is.
+func (u *Updater) SyncFS(fsys fs.FS, opts *AddFSOptions) error {
+	return u.addFS(fsys, opts, true)
+}
+
+func (u *Updater) addFS(fsys fs.FS, opts *AddFSOptions, sync bool) error {
+	if opts == nil {
+		opts = &AddFSOptions{}
+	}
+	existing := make(map[string]*FileHeader, len(u.dir))
+	for _, h := range u.dir {
+		existing[h.Name] = h.FileHeader
+	}
+	seen := make(map[string]bool)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !info.Mode().IsRegular() {
+			return errors.New("zip: cannot add non-regular file")
+		}
+		h, err := FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+		if d.IsDir() {
+			h.Name += "/"
+		}
+		seen[h.Name] = true
+		if old, ok := existing[h.Name]; ok && sync {
+			if d.IsDir() {
+				return nil
+			}
+			unchanged, err := u.syncUnchanged(fsys, name, old, info, opts)
+			if unchanged || err != nil {
+				return err
+			}
+		}
+
+		h.Method = Deflate
+		fw, err := u.AppendHeader(h, APPEND_MODE_OVERWRITE)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(fw, f)
+		return err
+	})
+	if err != nil || !opts.Delete {
+		return err
+	}
+	_, err = u.DeleteFunc(func(fh *FileHeader) bool {
+		return !seen[fh.Name]
+	})
+	return err
+}
+
+// syncUnchanged reports whether the file name from fsys is the same as the
+// existing file old in the zip archive. If only the modification time differs
+// and the CRC-32 checksum is compared, the modification time of the existing
+// file is updated.
+func (u *Updater) syncUnchanged(fsys fs.FS, name string, old *FileHeader, info fs.FileInfo, opts *AddFSOptions) (bool, error) {
+	if old.UncompressedSize64 != uint64(info.Size()) {
+		return false, nil
+	}
+	sameTime := sameModTime(old, info.ModTime())
+	if !opts.CompareCRC32 {
+		return sameTime, nil
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, f); err != nil {
+		return false, err
+	}
+	if crc.Sum32() != old.CRC32 {
+		return false, nil
+	}
+	if !sameTime {
+		err := u.UpdateHeader(old.Name, func(fh *FileHeader) {
+			fh.SetModTime(info.ModTime())
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sameModTime reports whether the modification time of fh is t, within the
+// precision of the timestamp stored in the zip archive.
+func sameModTime(fh *FileHeader, t time.Time) bool {
+	if len(stripExtra(fh.Extra, extTimeExtraID)) < len(fh.Extra) {
+		// The "extended timestamp" has a precision of one second.
+		return fh.Modified.Unix() == t.Unix()
+	}
+	// The MS-DOS timestamp has a precision of two seconds.
+	d := fh.Modified.Sub(t)
+	return d > -2*time.Second && d < 2*time.Second
+}
@@ -0,0 +1,151 @@
+package zip
+
+import (
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testReadFS checks the files in the zip archive have the content of the
+// files in fsys.
+func testReadFS(t *testing.T, r *Reader, fsys fstest.MapFS) {
+	t.Helper()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+		if f.Mode().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := string(fsys[f.Name].Data); string(b) != want {
+			t.Errorf("file %q: got %q, want %q", f.Name, b, want)
+		}
+	}
+	var want []string
+	for name, f := range fsys {
+		if f.Mode.IsDir() {
+			name += "/"
+		}
+		want = append(want, name)
+	}
+	slices.Sort(names)
+	slices.Sort(want)
+	if !slices.Equal(names, want) {
+		t.Errorf("got files %q, want %q", names, want)
+	}
+}
+
+func TestUpdaterAddFS(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal[:2])
+	fsys := fstest.MapFS{
+		"foo":       {Data: []byte("replaced data")},
+		"dir/a.txt": {Data: []byte("file in dir")},
+	}
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddFS(fsys, &AddFSOptions{Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The directory is synthesized by fstest.MapFS.
+	fsys["dir"] = &fstest.MapFile{Mode: 0755 | fs.ModeDir}
+	testReadFS(t, openTestZip(t, f), fsys)
+}
+
+func TestUpdaterSyncFS(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"dir":           {Mode: 0755 | fs.ModeDir, ModTime: modTime},
+		"dir/changed":   {Data: []byte("original data"), ModTime: modTime},
+		"dir/same-size": {Data: []byte("original data"), ModTime: modTime},
+		"touched":       {Data: []byte("original data"), ModTime: modTime},
+		"removed":       {Data: []byte("original data"), ModTime: modTime},
+	}
+	f := createTestZip(t, nil)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.AddFS(fsys, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["dir/changed"] = &fstest.MapFile{Data: []byte("changed data, longer"), ModTime: modTime.Add(time.Hour)}
+	// Changed without updating the modification time.
+	fsys["dir/same-size"] = &fstest.MapFile{Data: []byte("changed data!"), ModTime: modTime}
+	// The modification time is updated without changing the data.
+	fsys["touched"] = &fstest.MapFile{Data: []byte("original data"), ModTime: modTime.Add(time.Hour)}
+	fsys["new"] = &fstest.MapFile{Data: []byte("new data"), ModTime: modTime}
+	delete(fsys, "removed")
+
+	u, err = NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SyncFS(fsys, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	want := fstest.MapFS{
+		"dir":           fsys["dir"],
+		"dir/changed":   fsys["dir/changed"],
+		"dir/same-size": {Data: []byte("original data")},
+		"touched":       fsys["touched"],
+		"removed":       {Data: []byte("original data")},
+		"new":           fsys["new"],
+	}
+	testReadFS(t, r, want)
+	touched := testFileByName(t, r, "touched")
+	if !touched.Modified.Equal(modTime.Add(time.Hour)) {
+		t.Errorf("touched: got modification time %v, want %v", touched.Modified, modTime.Add(time.Hour))
+	}
+
+	// Check the CRC-32 checksum and delete the removed files.
+	u, err = NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["touched"].ModTime = modTime
+	if err := u.SyncFS(fsys, &AddFSOptions{CompareCRC32: true, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	// The touched file is not re-written, otherwise it is written after
+	// dir/same-size.
+	index := func(name string) int {
+		return slices.IndexFunc(u.dir, func(h *header) bool { return h.Name == name })
+	}
+	if index("touched") > index("dir/same-size") {
+		t.Errorf("touched is re-written")
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r = openTestZip(t, f)
+	testReadFS(t, r, fsys)
+	touched = testFileByName(t, r, "touched")
+	if !touched.Modified.Equal(modTime) {
+		t.Errorf("touched: got modification time %v, want %v", touched.Modified, modTime)
+	}
+}
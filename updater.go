@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
-	"path/filepath"
+	"io/fs"
 	"slices"
 	"strings"
+	"time"
 )
 
 const bufferSize int64 = 1 << 20 // 1M
@@ -26,58 +27,151 @@ const (
 	APPEND_MODE_KEEP_ORIGINAL
 )
 
+// readerWriterAt is the positional I/O interface used by the [Updater].
+type readerWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
 // sectionReaderWriter implements [io.Reader], [io.Writer], [io.Seeker],
-// [io.ReaderAt], [io.WriterAt] interfaces based on [io.ReadWriteSeeker].
+// [io.ReaderAt], [io.WriterAt] interfaces based on positional I/O. The read
+// and write offset and the size of the zip archive are tracked internally,
+// so the underlying file is safe for concurrent readers using ReadAt.
 type sectionReaderWriter struct {
-	rws io.ReadWriteSeeker
+	rw readerWriterAt
+	// f is the underlying file passed to the Updater, which may support
+	// truncation and syncing.
+	f any
+	// off is the current read and write offset.
+	off int64
+	// size is the current size of the zip archive.
+	size int64
+	// journal if non-nil saves the original data before it is overwritten.
+	journal *journal
 }
 
-func newSectionReaderWriter(rws io.ReadWriteSeeker) *sectionReaderWriter {
+func newSectionReaderWriter(f any, rw readerWriterAt, size int64) *sectionReaderWriter {
 	return &sectionReaderWriter{
-		rws: rws,
+		rw:   rw,
+		f:    f,
+		size: size,
 	}
 }
 
 func (s *sectionReaderWriter) ReadAt(p []byte, offset int64) (int, error) {
-	currOffset, err := s.rws.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return 0, err
+	return s.rw.ReadAt(p, offset)
+}
+
+func (s *sectionReaderWriter) WriteAt(p []byte, offset int64) (int, error) {
+	if s.journal != nil {
+		if err := s.journal.save(s, offset, int64(len(p))); err != nil {
+			return 0, err
+		}
 	}
-	defer s.rws.Seek(currOffset, io.SeekStart)
-	_, err = s.rws.Seek(offset, io.SeekStart)
-	if err != nil {
-		return 0, err
+	n, err := s.rw.WriteAt(p, offset)
+	s.size = max(s.size, offset+int64(n))
+	return n, err
+}
+
+func (s *sectionReaderWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	default:
+		return 0, errWhence
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.size
 	}
-	return s.rws.Read(p)
+	if offset < 0 {
+		return 0, errOffset
+	}
+	s.off = offset
+	return offset, nil
 }
 
-func (s *sectionReaderWriter) WriteAt(p []byte, offset int64) (n int, err error) {
-	currOffset, err := s.rws.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return 0, err
+func (s *sectionReaderWriter) Read(p []byte) (int, error) {
+	if s.off >= s.size {
+		return 0, io.EOF
 	}
-	defer s.rws.Seek(currOffset, io.SeekStart)
-	_, err = s.rws.Seek(offset, io.SeekStart)
-	if err != nil {
-		return 0, err
+	if remaining := s.size - s.off; int64(len(p)) > remaining {
+		p = p[:remaining]
 	}
-	return s.rws.Write(p)
+	n, err := s.rw.ReadAt(p, s.off)
+	s.off += int64(n)
+	if n == len(p) {
+		err = nil
+	}
+	return n, err
 }
 
-func (s *sectionReaderWriter) Seek(offset int64, whence int) (int64, error) {
-	return s.rws.Seek(offset, whence)
+func (s *sectionReaderWriter) Write(p []byte) (int, error) {
+	n, err := s.WriteAt(p, s.off)
+	s.off += int64(n)
+	return n, err
 }
 
-func (s *sectionReaderWriter) Read(p []byte) (n int, err error) {
-	return s.rws.Read(p)
+// Truncate changes the size of the underlying file if it supports
+// truncation, such as [os.File].
+func (s *sectionReaderWriter) Truncate(size int64) error {
+	t, ok := s.f.(truncater)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if s.journal != nil {
+		// The truncated original data is saved as if it is overwritten.
+		if err := s.journal.save(s, size, s.journal.size-size); err != nil {
+			return err
+		}
+	}
+	if err := t.Truncate(size); err != nil {
+		return err
+	}
+	s.size = size
+	return nil
 }
 
-func (s *sectionReaderWriter) Write(p []byte) (n int, err error) {
-	return s.rws.Write(p)
+// Sync commits the content of the underlying file to stable storage if it
+// supports syncing, such as [os.File].
+func (s *sectionReaderWriter) Sync() error {
+	if f, ok := s.f.(syncer); ok {
+		return f.Sync()
+	}
+	return nil
 }
 
 func (s *sectionReaderWriter) offset() (int64, error) {
-	return s.rws.Seek(0, io.SeekCurrent)
+	return s.off, nil
+}
+
+var (
+	errWhence = errors.New("Seek: invalid whence")
+	errOffset = errors.New("Seek: invalid offset")
+)
+
+// seekReaderWriterAt implements positional I/O on an [io.ReadWriteSeeker]
+// without ReadAt and WriteAt methods by seeking it before each read and write.
+type seekReaderWriterAt struct {
+	rws io.ReadWriteSeeker
+}
+
+func (s seekReaderWriterAt) ReadAt(p []byte, offset int64) (int, error) {
+	if _, err := s.rws.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// ReadAt should read len(p) bytes unless the end of file is reached.
+	n, err := io.ReadFull(s.rws, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s seekReaderWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	if _, err := s.rws.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return s.rws.Write(p)
 }
 
 type Directory struct {
@@ -93,34 +187,185 @@ func (d *Directory) HeaderOffset() int64 {
 // decompress the whole file.
 type Updater struct {
 	rw          *sectionReaderWriter
-	offset      int64
 	dir         []*header
 	last        *fileWriter
 	closed      bool
 	compressors map[uint16]Compressor
 	comment     string
 
+	// view is the cached read-only view of the zip archive returned by
+	// Files and Open. It is reset whenever the zip archive is modified.
+	view *Reader
+
 	// Some JAR files are zip files with a prefix that is a bash script.
-	// The baseOffset field is the start of the zip file proper.
+	// The baseOffset field is the start of the zip file proper, the offsets
+	// stored in the directory record are relative to it. It is zero if the
+	// offsets are relative to the start of the file.
 	baseOffset int64
+	// prefixLen is the length of the data before the first file, such as
+	// the bash script or the stub of a self-extracting archive.
+	prefixLen int64
 	// dirOffset is the offset to write the directory record.
 	// Note that the dirOffset may not equal to the last file data end offset.
 	dirOffset int64
+
+	// journalPath is the path of the journal file, see WithJournal.
+	journalPath string
+
+	// reuseSpace enables the free space reuse, see WithSpaceReuse.
+	reuseSpace bool
+	// free is the sorted list of the free space ranges in the zip archive.
+	free [][2]int64
+	// gap if non-nil writes the last file into the free space.
+	gap *gapWriter
+
+	// seeker is the io.ReadWriteSeeker passed to NewUpdater, which is left
+	// at the end of the zip archive by Close.
+	seeker io.Seeker
+
+	// requireTruncate refuses to update the zip archive which can not be
+	// truncated, see WithRequireTruncate.
+	requireTruncate bool
+
+	// preDir is the block written before the directory record, see
+	// WithDropPreDirectoryBlock.
+	preDir *PreDirectoryBlock
+	// dropPreDir drops the block before the directory record.
+	dropPreDir bool
 }
 
-// NewUpdater returns a new Updater from [io.ReadWriteSeeker], which is
-// assumed to have the given size in bytes.
-func NewUpdater(rws io.ReadWriteSeeker) (*Updater, error) {
+// An UpdaterOption configures an [Updater] created by [NewUpdater].
+type UpdaterOption func(u *Updater)
+
+// WithJournal enables the transactional mode of the [Updater], which makes
+// the update crash-safe. Before any original data of the zip archive is
+// overwritten, it is saved into the journal file at path together with the
+// original end of central directory record and the size of the zip archive.
+//
+// The changes are committed and the journal file is removed by
+// [Updater.Close], or discarded by [Updater.Rollback]. If the update was
+// interrupted (e.g. the process crashed), the journal file is left behind and
+// [NewUpdater] with the same journal path rolls back the zip archive to its
+// original content before opening it, or just removes the journal if the
+// update was already committed.
+//
+// The transactional mode requires the [io.ReadWriteSeeker] to support
+// truncation like [os.File], since the zip archive may need to be shrunk to
+// its original size on rollback.
+func WithJournal(path string) UpdaterOption {
+	return func(u *Updater) {
+		u.journalPath = path
+	}
+}
+
+// WithRequireTruncate makes [NewUpdater] and [NewUpdaterAt] refuse to update
+// the zip archive with an [errors.ErrUnsupported] error if the underlying
+// file does not implement Truncate(int64) error like [os.File].
+//
+// By default, if the zip archive shrinks but can not be truncated,
+// [Updater.Close] pads the zip archive with zeros before the directory record
+// to keep its size.
+func WithRequireTruncate() UpdaterOption {
+	return func(u *Updater) {
+		u.requireTruncate = true
+	}
+}
+
+// WithDropPreDirectoryBlock makes the [Updater] drop the data stored between
+// the last file and the central directory, such as the APK Signing Block, see
+// [Reader.PreDirectoryBlock].
+//
+// By default, the block is kept in memory and written right before the
+// directory record by [Updater.Close]. A block larger than 64 MiB is not kept,
+// and [NewUpdater] fails unless the block is dropped. Note that the APK Signing Block is
+// invalidated by any change to the zip archive, the APK needs to be signed
+// again after the update.
+func WithDropPreDirectoryBlock() UpdaterOption {
+	return func(u *Updater) {
+		u.dropPreDir = true
+	}
+}
+
+// NewUpdater returns a new Updater from [io.ReadWriteSeeker]. If rws also
+// implements [io.ReaderAt] and [io.WriterAt] like [os.File], the Updater uses
+// positional I/O as [NewUpdaterAt], otherwise it seeks rws before each read
+// and write.
+func NewUpdater(rws io.ReadWriteSeeker, opts ...UpdaterOption) (*Updater, error) {
 	size, err := rws.Seek(0, io.SeekEnd)
 	if err != nil {
 		return nil, err
 	}
+	rw, ok := rws.(readerWriterAt)
+	if !ok {
+		rw = seekReaderWriterAt{rws: rws}
+	}
+	u, err := newUpdater(newSectionReaderWriter(rws, rw, size), opts)
+	if err != nil {
+		return nil, err
+	}
+	u.seeker = rws
+	return u, nil
+}
+
+// NewUpdaterAt returns a new Updater using the positional I/O of rw, which is
+// assumed to have the given size in bytes. The Updater never changes the
+// read and write offset of rw, the content of the zip archive can be read
+// concurrently using ReadAt while it is not being modified.
+//
+// If rw implements Truncate(int64) error like [os.File], the zip archive is
+// truncated when it shrinks, see [Updater.Close].
+func NewUpdaterAt(rw interface {
+	io.ReaderAt
+	io.WriterAt
+}, size int64, opts ...UpdaterOption) (*Updater, error) {
+	return newUpdater(newSectionReaderWriter(rw, rw, size), opts)
+}
+
+func newUpdater(rw *sectionReaderWriter, opts []UpdaterOption) (*Updater, error) {
 	zu := &Updater{
-		rw: newSectionReaderWriter(rws),
+		rw: rw,
+	}
+	for _, opt := range opts {
+		opt(zu)
+	}
+	if zu.requireTruncate {
+		if _, ok := rw.f.(truncater); !ok {
+			return nil, fmt.Errorf("zip: truncate: %w", errors.ErrUnsupported)
+		}
 	}
-	if err = zu.init(size); err != nil && err != ErrInsecurePath {
+	if zu.journalPath != "" {
+		if _, ok := rw.f.(truncater); !ok {
+			return nil, fmt.Errorf("zip: journal: truncate: %w", errors.ErrUnsupported)
+		}
+		// Recover the interrupted update before reading the directory
+		// record, which may be corrupted. The size of the zip archive is
+		// restored to its original size.
+		if err := recoverJournal(zu.journalPath, rw); err != nil {
+			return nil, err
+		}
+	}
+	size := rw.size
+	if err := zu.init(size); err != nil && err != ErrInsecurePath {
 		return nil, err
 	}
+	if zu.reuseSpace {
+		if err := zu.initFreeSpace(); err != nil {
+			return nil, err
+		}
+	}
+	if zu.journalPath != "" {
+		j, err := createJournal(zu.journalPath, size)
+		if err != nil {
+			return nil, err
+		}
+		// Save the original directory record and the end of central
+		// directory record, which are overwritten by almost every update.
+		if err := j.save(rw, zu.dirOffset, size-zu.dirOffset); err != nil {
+			j.remove()
+			return nil, err
+		}
+		rw.journal = j
+	}
 	return zu, nil
 }
 
@@ -130,7 +375,7 @@ func (u *Updater) init(size int64) error {
 		return err
 	}
 	u.baseOffset = baseOffset
-	u.dirOffset = int64(end.directoryOffset)
+	u.dirOffset = u.baseOffset + int64(end.directoryOffset)
 	// Since the number of directory records is not validated, it is not
 	// safe to preallocate r.File without first checking that the specified
 	// number of files is reasonable, since a malformed archive may
@@ -141,7 +386,7 @@ func (u *Updater) init(size int64) error {
 		u.dir = make([]*header, 0, end.directoryRecords)
 	}
 	u.comment = end.comment
-	if _, err = u.rw.Seek(u.baseOffset+int64(end.directoryOffset), io.SeekStart); err != nil {
+	if _, err = u.rw.Seek(u.dirOffset, io.SeekStart); err != nil {
 		return err
 	}
 
@@ -173,18 +418,22 @@ func (u *Updater) init(size int64) error {
 
 	// Ensure the directory record is ordered by file header offset.
 	slices.SortFunc(u.dir, sortDirectoryFunc)
+	u.prefixLen = u.dirOffset
+	if len(u.dir) > 0 {
+		u.prefixLen = int64(u.dir[0].offset)
+	}
+	if err := u.initPreDirectoryBlock(); err != nil {
+		return err
+	}
 	for _, d := range u.dir {
-		if d.Name == "" {
-			// Zip permits an empty file name field.
-			continue
-		}
-		// The zip specification states that names must use forward slashes,
-		// so consider any backslashes in the name insecure.
-		if !filepath.IsLocal(d.Name) || strings.Contains(d.Name, "\\") {
+		if insecurePath(d.Name) {
 			return ErrInsecurePath
 		}
 	}
-	return nil
+	// New files and the directory record are written from the end of the
+	// file data.
+	_, err = u.rw.Seek(u.dirOffset, io.SeekStart)
+	return err
 }
 
 // Append adds a file to the zip file using the provided name.
@@ -213,18 +462,29 @@ func (u *Updater) Append(name string, mode AppendMode) (io.Writer, error) {
 	return u.AppendHeader(h, mode)
 }
 
+// closeLast finishes the file data written by the last call of
+// [Updater.AppendHeader].
+func (u *Updater) closeLast() error {
+	if u.last == nil || u.last.closed {
+		return nil
+	}
+	u.view = nil
+	if err := u.last.close(); err != nil {
+		return err
+	}
+	offset, err := u.rw.offset()
+	if err != nil {
+		return err
+	}
+	if u.dirOffset < offset {
+		u.dirOffset = offset
+	}
+	return u.finishFree()
+}
+
 func (u *Updater) prepare(fh *FileHeader) error {
-	if u.last != nil && !u.last.closed {
-		if err := u.last.close(); err != nil {
-			return err
-		}
-		offset, err := u.rw.offset()
-		if err != nil {
-			return err
-		}
-		if u.dirOffset < offset {
-			u.dirOffset = offset
-		}
+	if err := u.closeLast(); err != nil {
+		return err
 	}
 	if len(u.dir) > 0 && u.dir[len(u.dir)-1].FileHeader == fh {
 		// See https://golang.org/issue/11144 confusion.
@@ -251,33 +511,6 @@ func (u *Updater) AppendHeader(fh *FileHeader, mode AppendMode) (io.Writer, erro
 		return nil, err
 	}
 
-	var err error
-	var offset int64 = -1
-	var existingDirIndex int = -1
-	if mode == APPEND_MODE_OVERWRITE {
-		for i, d := range u.dir {
-			if d.Name == fh.Name {
-				offset = int64(d.offset)
-				existingDirIndex = i
-				break
-			}
-		}
-	}
-	if offset < 0 {
-		offset = u.dirOffset
-	}
-	if existingDirIndex >= 0 {
-		if offset, err = u.removeFile(existingDirIndex); err != nil {
-			return nil, err
-		}
-	}
-
-	// Seek the file offset.
-	if _, err := u.rw.Seek(offset, io.SeekStart); err != nil {
-		return nil, err
-	}
-	u.offset = offset
-
 	// The ZIP format has a sad state of affairs regarding character encoding.
 	// Officially, the name and comment fields are supposed to be encoded
 	// in CP-437 (which is mostly compatible with ASCII), unless the UTF-8
@@ -294,17 +527,13 @@ func (u *Updater) AppendHeader(fh *FileHeader, mode AppendMode) (io.Writer, erro
 	//
 	// For the case, where the user explicitly wants to specify the encoding
 	// as UTF-8, they will need to set the flag bit themselves.
-	utf8Valid1, utf8Require1 := detectUTF8(fh.Name)
-	utf8Valid2, utf8Require2 := detectUTF8(fh.Comment)
-	switch {
-	case fh.NonUTF8:
-		fh.Flags &^= 0x800
-	case (utf8Require1 || utf8Require2) && (utf8Valid1 && utf8Valid2):
-		fh.Flags |= 0x800
-	}
+	setUTF8Flag(fh)
 
 	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersion20 // preserve compatibility byte
-	fh.ReaderVersion = zipVersion20
+	fh.ReaderVersion = readerVersion(fh.Method)
+	if fh.Method == LZMA && u.compressors[LZMA] == nil && isBuiltinCompressor(LZMA) {
+		fh.Flags |= lzmaEOSFlag // the built-in compressor writes the end of stream marker
+	}
 
 	// If Modified is set, this takes precedence over MS-DOS timestamp fields.
 	if !fh.Modified.IsZero() {
@@ -324,23 +553,20 @@ func (u *Updater) AppendHeader(fh *FileHeader, mode AppendMode) (io.Writer, erro
 		//
 		// This format happens to be identical for both local and central header
 		// if modification time is the only timestamp being encoded.
-		var mbuf [9]byte // 2*SizeOf(uint16) + SizeOf(uint8) + SizeOf(uint32)
-		mt := uint32(fh.Modified.Unix())
-		eb := writeBuf(mbuf[:])
-		eb.uint16(extTimeExtraID)
-		eb.uint16(5)  // Size: SizeOf(uint8) + SizeOf(uint32)
-		eb.uint8(1)   // Flags: ModTime
-		eb.uint32(mt) // ModTime
-		fh.Extra = append(fh.Extra, mbuf[:]...)
+		fh.Extra = append(fh.Extra, extTimeExtra(fh.Modified)...)
 	}
 
 	var (
-		ow io.Writer
-		fw *fileWriter
+		ow   io.Writer
+		fw   *fileWriter
+		comp Compressor
 	)
 	h := &header{
 		FileHeader: fh,
-		offset:     uint64(u.offset),
+	}
+	w, err := u.seekAppendOffset(h, mode)
+	if err != nil {
+		return nil, err
 	}
 	if strings.HasSuffix(fh.Name, "/") {
 		// Set the compression method to Store to ensure data length is truly zero,
@@ -361,42 +587,188 @@ func (u *Updater) AppendHeader(fh *FileHeader, mode AppendMode) (io.Writer, erro
 		fh.Flags |= 0x8 // we will write a data descriptor
 
 		fw = &fileWriter{
-			zipw:      u.rw,
-			compCount: &countWriter{w: u.rw},
+			zipw:      w,
+			compCount: &countWriter{w: w},
 			crc32:     crc32.NewIEEE(),
 		}
-		comp := u.compressor(fh.Method)
+		comp = u.compressor(fh.Method)
 		if comp == nil {
 			return nil, ErrAlgorithm
 		}
-		var err error
-		fw.comp, err = comp(fw.compCount)
+		comp, err = encryptCompressor(fh, comp)
 		if err != nil {
 			return nil, err
 		}
-		fw.rawCount = &countWriter{w: fw.comp}
 		fw.header = h
 		ow = fw
 	}
-	u.dir = append(u.dir, h)
-	// No need to re-sort u.dir here since the new created header is write
-	// to the end of the files.
-	if err := writeHeader(u.rw, h); err != nil {
+	u.insertHeader(h)
+	if err := writeHeader(w, h); err != nil {
 		return nil, err
 	}
+	if fw != nil {
+		// The compressor may write the header of the stream right away, see
+		// Writer.CreateHeader.
+		if fw.comp, err = comp(fw.compCount); err != nil {
+			return nil, err
+		}
+		fw.rawCount = &countWriter{w: fw.comp}
+	}
 	// If we're creating a directory, fw is nil.
 	u.last = fw
-	offset, err = u.rw.offset()
+	offset, err := u.rw.offset()
 	if err != nil {
 		return nil, err
 	}
 	if u.dirOffset < offset {
 		u.dirOffset = offset
 	}
+	if fw == nil {
+		if err := u.finishFree(); err != nil {
+			return nil, err
+		}
+	}
 
 	return ow, nil
 }
 
+// insertHeader adds the header of the new file to u.dir, which is sorted by
+// the header offsets.
+func (u *Updater) insertHeader(h *header) {
+	i, _ := slices.BinarySearchFunc(u.dir, h, sortDirectoryFunc)
+	// Keep the files with the same offset in the order of insertion.
+	for i < len(u.dir) && u.dir[i].offset == h.offset {
+		i++
+	}
+	u.dir = slices.Insert(u.dir, i, h)
+	u.view = nil
+}
+
+// seekAppendOffset sets the offset of the new file h and seeks to it, it
+// returns the writer to write the file to. If mode is [APPEND_MODE_OVERWRITE]
+// and the file name already exists in the zip archive, the existing file data
+// is removed.
+func (u *Updater) seekAppendOffset(h *header, mode AppendMode) (io.Writer, error) {
+	if u.reuseSpace {
+		return u.allocate(h, mode)
+	}
+	var err error
+	var offset int64 = -1
+	var existingDirIndex int = -1
+	if mode == APPEND_MODE_OVERWRITE {
+		for i, d := range u.dir {
+			if d.Name == h.Name {
+				offset = int64(d.offset)
+				existingDirIndex = i
+				break
+			}
+		}
+	}
+	if offset < 0 {
+		offset = u.dirOffset
+	}
+	if existingDirIndex >= 0 {
+		if offset, err = u.removeFile(existingDirIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	// Seek the file offset.
+	if _, err := u.rw.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h.offset = uint64(offset)
+	return u.rw, nil
+}
+
+// AppendRaw adds a file to the zip archive using the provided [FileHeader]
+// and returns a [Writer] to which the file contents should be written. The
+// file's contents must be written to the io.Writer before the next call to
+// [Updater.Append], [Updater.AppendHeader], [Updater.AppendRaw] or
+// [Updater.Close]. The mode is handled in the same way as
+// [Updater.AppendHeader].
+//
+// In contrast to [Updater.AppendHeader], the bytes passed to Writer are not
+// compressed, and the CRC32 and sizes of fh are written as is. A data
+// descriptor is only written if the caller set it in the fh.Flags.
+func (u *Updater) AppendRaw(fh *FileHeader, mode AppendMode) (io.Writer, error) {
+	if err := u.prepare(fh); err != nil {
+		return nil, err
+	}
+
+	fh.CompressedSize = uint32(min(fh.CompressedSize64, uint32max))
+	fh.UncompressedSize = uint32(min(fh.UncompressedSize64, uint32max))
+
+	h := &header{
+		FileHeader: fh,
+		raw:        true,
+	}
+	w, err := u.seekAppendOffset(h, mode)
+	if err != nil {
+		return nil, err
+	}
+	u.insertHeader(h)
+	if err := writeHeader(w, h); err != nil {
+		return nil, err
+	}
+	offset, err := u.rw.offset()
+	if err != nil {
+		return nil, err
+	}
+	if u.dirOffset < offset {
+		u.dirOffset = offset
+	}
+
+	if strings.HasSuffix(fh.Name, "/") {
+		u.last = nil
+		if err := u.finishFree(); err != nil {
+			return nil, err
+		}
+		return dirWriter{}, nil
+	}
+
+	fw := &fileWriter{
+		header: h,
+		zipw:   w,
+	}
+	u.last = fw
+	return fw, nil
+}
+
+// Copy copies the file f (obtained from a [Reader]) into the zip archive. It
+// copies the raw form directly bypassing decompression, compression, and
+// validation. The mode is handled in the same way as [Updater.AppendHeader].
+//
+// The file f must not be obtained from the zip archive being updated.
+func (u *Updater) Copy(f *File, mode AppendMode) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	// Copy the FileHeader so u doesn't store a pointer to the data
+	// of f's entire archive.
+	fh := f.FileHeader
+	fw, err := u.AppendRaw(&fh, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// setUTF8Flag sets or clears the UTF-8 flag bit of fh depending on the
+// encoding of the Name and Comment, see [Updater.AppendHeader].
+func setUTF8Flag(fh *FileHeader) {
+	utf8Valid1, utf8Require1 := detectUTF8(fh.Name)
+	utf8Valid2, utf8Require2 := detectUTF8(fh.Comment)
+	switch {
+	case fh.NonUTF8:
+		fh.Flags &^= 0x800
+	case (utf8Require1 || utf8Require2) && (utf8Valid1 && utf8Valid2):
+		fh.Flags |= 0x800
+	}
+}
+
 // removeFile removes file in zip by rewinding data and directory record.
 func (u *Updater) removeFile(dirIndex int) (int64, error) {
 	// start is the file header offset.
@@ -411,50 +783,333 @@ func (u *Updater) removeFile(dirIndex int) (int64, error) {
 	// size is the file header and compressed data size.
 	var size = end - start
 
-	// Allocate buffer to rewind file data.
-	var buffer = make([]byte, bufferSize)
-	var rp int64 = end   // read point
-	var wp int64 = start // write point
-	// Rewind data in buffer size block.
-	for rp < u.dirOffset-bufferSize {
-		n, err := u.rw.ReadAt(buffer, rp)
-		if err != nil {
-			return 0, fmt.Errorf("zip: rewind data: ReadAt: %w", err)
-		}
-		_, err = u.rw.WriteAt(buffer[:n], wp)
-		if err != nil {
-			return 0, fmt.Errorf("zip: rewind data: WriteAt: %w", err)
-		}
-		rp += int64(n)
-		wp += int64(n)
-	}
-	// Rewind remaining data that smaller than the buffer size block.
-	if rp < u.dirOffset {
-		n, err := u.rw.ReadAt(buffer[:u.dirOffset-rp], rp)
-		if err != nil {
-			return 0, fmt.Errorf("zip: rewind data: ReadAt: %w", err)
-		}
-		_, err = u.rw.WriteAt(buffer[:n], wp)
-		if err != nil {
-			return 0, fmt.Errorf("zip: rewind data: ReadAt: %w", err)
-		}
-		rp += int64(n)
-		wp += int64(n)
-		// assert: rewind data before directory record
-		if rp != u.dirOffset {
-			return 0, errors.New("zip: rewind data: read data before directory failed")
-		}
+	// Rewind the data after the file to the file header offset.
+	if err := u.moveData(start, end, u.dirOffset); err != nil {
+		return 0, fmt.Errorf("zip: rewind data: %w", err)
 	}
+	wp := start + u.dirOffset - end
+	// The data up to the directory record is moved with the following files,
+	// new files are written from the new end of the data.
+	u.dirOffset -= size
 	// Remove deleted file directory record.
+	u.view = nil
 	u.dir = append(u.dir[:dirIndex], u.dir[dirIndex+1:len(u.dir)]...)
 	// Update the file header offset in directory record.
 	for i := dirIndex; i < len(u.dir); i++ {
 		u.dir[i].offset -= uint64(size)
-		u.dir[i].Extra = nil // Will re-generate zip64 extra data when calling
 	}
 	return wp, nil
 }
 
+// moveData copies the data in range [start, end) to the offset dst.
+// The source and destination range may overlap.
+func (u *Updater) moveData(dst, start, end int64) error {
+	if dst == start || start >= end {
+		return nil
+	}
+	var buffer = make([]byte, min(bufferSize, end-start))
+	if dst < start {
+		// Move data forward from the beginning of the range.
+		for rp := start; rp < end; {
+			n := min(int64(len(buffer)), end-rp)
+			if _, err := u.rw.ReadAt(buffer[:n], rp); err != nil {
+				return fmt.Errorf("ReadAt: %w", err)
+			}
+			if _, err := u.rw.WriteAt(buffer[:n], dst+rp-start); err != nil {
+				return fmt.Errorf("WriteAt: %w", err)
+			}
+			rp += n
+		}
+		return nil
+	}
+	// Move data backward from the end of the range, to avoid overwriting the
+	// data not copied yet.
+	for rp := end; rp > start; {
+		n := min(int64(len(buffer)), rp-start)
+		rp -= n
+		if _, err := u.rw.ReadAt(buffer[:n], rp); err != nil {
+			return fmt.Errorf("ReadAt: %w", err)
+		}
+		if _, err := u.rw.WriteAt(buffer[:n], dst+rp-start); err != nil {
+			return fmt.Errorf("WriteAt: %w", err)
+		}
+	}
+	return nil
+}
+
+// shiftData moves all file data after offset by delta bytes and updates the
+// header offsets of the moved files.
+func (u *Updater) shiftData(offset, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	cursor, err := u.rw.offset()
+	if err != nil {
+		return err
+	}
+	if err := u.moveData(offset+delta, offset, u.dirOffset); err != nil {
+		return fmt.Errorf("zip: shift data: %w", err)
+	}
+	u.view = nil
+	for _, h := range u.dir {
+		if int64(h.offset) >= offset {
+			h.offset = uint64(int64(h.offset) + delta)
+		}
+	}
+	for i, f := range u.free {
+		if f[0] >= offset {
+			u.free[i] = [2]int64{f[0] + delta, f[1] + delta}
+		}
+	}
+	u.dirOffset += delta
+	if cursor >= offset {
+		cursor += delta
+	}
+	_, err = u.rw.Seek(cursor, io.SeekStart)
+	return err
+}
+
+// Delete removes the files with the given names from the zip archive.
+// The file data after each removed file is rewound to fill the gap, unless
+// [WithSpaceReuse] is enabled, and the directory record is re-written when
+// calling [Updater.Close].
+//
+// If a name appears more than once in the zip archive (see
+// [APPEND_MODE_KEEP_ORIGINAL]), all files with that name are removed.
+// If any of the names does not exist in the zip archive, Delete returns an
+// [fs.ErrNotExist] error and no file is removed.
+func (u *Updater) Delete(names ...string) error {
+	for _, name := range names {
+		if !slices.ContainsFunc(u.dir, func(h *header) bool { return h.Name == name }) {
+			return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	_, err := u.DeleteFunc(func(fh *FileHeader) bool {
+		return slices.Contains(names, fh.Name)
+	})
+	return err
+}
+
+// DeleteFunc removes all files from the zip archive for which fn returns true
+// and reports the number of removed files. The [FileHeader] passed to fn must
+// not be modified.
+func (u *Updater) DeleteFunc(fn func(fh *FileHeader) bool) (int, error) {
+	if u.closed {
+		return 0, errors.New("zip: delete from closed updater")
+	}
+	if err := u.closeLast(); err != nil {
+		return 0, err
+	}
+	offset, err := u.rw.offset()
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	// Remove files from the end of the archive to keep the indexes of the
+	// remaining files stable.
+	for i := len(u.dir) - 1; i >= 0; i-- {
+		if !fn(u.dir[i].FileHeader) {
+			continue
+		}
+		if u.reuseSpace {
+			if _, err := u.freeFile(i); err != nil {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+		start := int64(u.dir[i].offset)
+		end := u.dirOffset
+		if i < len(u.dir)-1 {
+			end = int64(u.dir[i+1].offset)
+		}
+		if _, err := u.removeFile(i); err != nil {
+			return removed, err
+		}
+		if start < offset {
+			offset -= end - start
+		}
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if u.reuseSpace {
+		offset = u.dataEnd()
+	}
+	// Rewound data ends before offset, the remaining bytes up to dirOffset
+	// will be cleaned when writing the directory record.
+	if _, err := u.rw.Seek(offset, io.SeekStart); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// Rename renames the file oldName in the zip archive to newName without
+// rewriting the file data. Only the local file header and the directory
+// record of the file are updated, the data after the file is moved only when
+// the length of the file name changes.
+//
+// Rename returns an [fs.ErrNotExist] error if oldName does not exist and an
+// [fs.ErrExist] error if newName already exists in the zip archive. Renaming
+// a file to its own name does nothing.
+func (u *Updater) Rename(oldName, newName string) error {
+	if oldName == newName {
+		if u.closed {
+			return errors.New("zip: rename in closed updater")
+		}
+		if !slices.ContainsFunc(u.dir, func(h *header) bool { return h.Name == oldName }) {
+			return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+		}
+		return nil
+	}
+	if slices.ContainsFunc(u.dir, func(h *header) bool { return h.Name == newName }) {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrExist}
+	}
+	if strings.HasSuffix(oldName, "/") != strings.HasSuffix(newName, "/") {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrInvalid}
+	}
+	return u.UpdateHeader(oldName, func(fh *FileHeader) {
+		fh.Name = newName
+	})
+}
+
+// UpdateHeader calls fn to modify the metadata of the named file, such as
+// Name, Comment, Modified or the mode set by [FileHeader.SetMode], and writes
+// the changes to the local file header and the directory record without
+// rewriting the file data. The data after the file is moved only when the
+// length of the local file header changes.
+//
+// The fields describing the file data (Method, Flags, CRC32, the sizes and
+// the ReaderVersion) are preserved, changes made by fn to them are discarded,
+// except for the UTF-8 flag bit which is updated from the new Name and
+// Comment. If the name appears more than once in the zip archive, all files
+// with that name are updated.
+//
+// UpdateHeader returns an [fs.ErrNotExist] error if the name does not exist
+// in the zip archive.
+func (u *Updater) UpdateHeader(name string, fn func(fh *FileHeader)) error {
+	if u.closed {
+		return errors.New("zip: update header of closed updater")
+	}
+	if err := u.closeLast(); err != nil {
+		return err
+	}
+	var found bool
+	// Collect the headers first, the order of u.dir is unchanged but the
+	// offsets may be shifted by updateHeader.
+	for _, h := range slices.Clone(u.dir) {
+		if h.Name != name {
+			continue
+		}
+		found = true
+		if err := u.updateHeader(h, fn); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return &fs.PathError{Op: "update", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (u *Updater) updateHeader(h *header, fn func(fh *FileHeader)) error {
+	// Read the local file header.
+	var buf [fileHeaderLen]byte
+	if _, err := u.rw.ReadAt(buf[:], int64(h.offset)); err != nil {
+		return err
+	}
+	b := readBuf(buf[:])
+	if sig := b.uint32(); sig != fileHeaderSignature {
+		return ErrFormat
+	}
+	b = b[2:] // skip over reader version
+	localFlags := b.uint16()
+	b = b[18:] // skip over method, modified time and date, crc32 and sizes
+	filenameLen := int(b.uint16())
+	extraLen := int(b.uint16())
+	localExtra := make([]byte, extraLen)
+	if _, err := u.rw.ReadAt(localExtra, int64(h.offset)+fileHeaderLen+int64(filenameLen)); err != nil {
+		return err
+	}
+
+	u.view = nil
+	fh := h.FileHeader
+	old := *fh
+	fn(fh)
+	// Restore the fields describing the file data.
+	fh.Method = old.Method
+	fh.Flags = old.Flags
+	fh.CRC32 = old.CRC32
+	fh.CompressedSize = old.CompressedSize
+	fh.UncompressedSize = old.UncompressedSize
+	fh.CompressedSize64 = old.CompressedSize64
+	fh.UncompressedSize64 = old.UncompressedSize64
+	fh.ReaderVersion = old.ReaderVersion
+
+	if strings.HasSuffix(fh.Name, "/") != strings.HasSuffix(old.Name, "/") {
+		*fh = old
+		return errors.New("zip: cannot change a file to a directory or vice versa")
+	}
+	if len(fh.Name) > uint16max {
+		*fh = old
+		return errLongName
+	}
+	if len(fh.Comment) > uint16max {
+		*fh = old
+		return errors.New("zip: FileHeader.Comment too long")
+	}
+	setUTF8Flag(fh)
+	if !fh.Modified.IsZero() && !fh.Modified.Equal(old.Modified) {
+		// Replace the "extended timestamp" in both the local and
+		// central extra data, see Updater.AppendHeader.
+		fh.ModifiedDate, fh.ModifiedTime = timeToMsDosTime(fh.Modified)
+		ext := extTimeExtra(fh.Modified)
+		fh.Extra = append(stripExtra(fh.Extra, extTimeExtraID), ext...)
+		localExtra = append(stripExtra(localExtra, extTimeExtraID), ext...)
+	}
+	if len(fh.Extra) > uint16max || len(localExtra) > uint16max {
+		*fh = old
+		return errLongExtra
+	}
+
+	// Re-write the local file header, the fields not stored in
+	// the FileHeader are kept as is.
+	wb := writeBuf(buf[6:])
+	wb.uint16(localFlags&^0x800 | fh.Flags&0x800)
+	wb = wb[2:] // skip over method
+	wb.uint16(fh.ModifiedTime)
+	wb.uint16(fh.ModifiedDate)
+	wb = wb[12:] // skip over crc32, compressed size and uncompressed size
+	wb.uint16(uint16(len(fh.Name)))
+	wb.uint16(uint16(len(localExtra)))
+
+	oldLen := int64(fileHeaderLen + filenameLen + extraLen)
+	newLen := int64(fileHeaderLen + len(fh.Name) + len(localExtra))
+	if err := u.shiftData(int64(h.offset)+oldLen, newLen-oldLen); err != nil {
+		return err
+	}
+	local := make([]byte, 0, newLen)
+	local = append(local, buf[:]...)
+	local = append(local, fh.Name...)
+	local = append(local, localExtra...)
+	if _, err := u.rw.WriteAt(local, int64(h.offset)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RegisterCompressor registers or overrides a custom compressor for a specific
+// method ID. If a compressor for a given method is not found, [Updater] will
+// default to looking up the compressor at the package level.
+func (u *Updater) RegisterCompressor(method uint16, comp Compressor) {
+	if u.compressors == nil {
+		u.compressors = make(map[uint16]Compressor)
+	}
+	u.compressors[method] = comp
+}
+
 func (u *Updater) compressor(method uint16) Compressor {
 	comp := u.compressors[method]
 	if comp == nil {
@@ -463,11 +1118,60 @@ func (u *Updater) compressor(method uint16) Compressor {
 	return comp
 }
 
+// Files returns the files currently stored in the zip archive, in the order
+// of their header offsets. The result reflects the files appended, overwritten,
+// renamed and deleted by the Updater so far, except the file still being
+// written by the last call of [Updater.AppendHeader] or [Updater.AppendRaw],
+// which is included once it is finished by the next call to the Updater.
+//
+// The returned files read their contents from the zip archive being updated,
+// they must not be used after the zip archive is modified again or the
+// Updater is closed.
+func (u *Updater) Files() []*File {
+	return slices.Clone(u.reader().File)
+}
+
+// Open opens the named file in the zip archive being updated, using the
+// semantics of fs.FS.Open, see [Reader.Open]. The content is the same as the
+// files returned by [Updater.Files].
+func (u *Updater) Open(name string) (fs.File, error) {
+	return u.reader().Open(name)
+}
+
+// reader returns the read-only view of the zip archive being updated.
+func (u *Updater) reader() *Reader {
+	if u.view != nil {
+		return u.view
+	}
+	r := &Reader{
+		r:          u.rw,
+		File:       make([]*File, 0, len(u.dir)),
+		Comment:    u.comment,
+		baseOffset: u.baseOffset,
+		preDir:     u.preDir,
+	}
+	for _, h := range u.dir {
+		if u.last != nil && !u.last.closed && u.last.header == h {
+			// The file data is not finished yet.
+			continue
+		}
+		r.File = append(r.File, &File{
+			FileHeader:   *h.FileHeader,
+			zip:          r,
+			zipr:         u.rw,
+			headerOffset: int64(h.offset),
+		})
+	}
+	u.view = r
+	return r
+}
+
 func (u *Updater) SetComment(comment string) error {
 	if len(comment) > uint16max {
 		return errors.New("zip: Writer.Comment too long")
 	}
 	u.comment = comment
+	u.view = nil
 	return nil
 }
 
@@ -475,13 +1179,55 @@ func (u *Updater) GetComment() string {
 	return u.comment
 }
 
+// SetPrefix replaces the data before the first file of the zip archive, such
+// as the bash script of a JAR file or the stub of a self-extracting archive,
+// with the content read from r. The file data is moved to make room for the
+// new prefix, the prefix is removed if r is empty.
+//
+// The offsets stored in the directory record are relative to the prefix if
+// they were in the original zip archive, otherwise they are relative to the
+// start of the file. The original prefix is kept byte for byte unless
+// SetPrefix is called.
+func (u *Updater) SetPrefix(r io.Reader) error {
+	if u.closed {
+		return errors.New("zip: set prefix of closed updater")
+	}
+	if err := u.closeLast(); err != nil {
+		return err
+	}
+	prefix, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	delta := int64(len(prefix)) - u.prefixLen
+	if err := u.shiftData(u.prefixLen, delta); err != nil {
+		return err
+	}
+	if _, err := u.rw.WriteAt(prefix, 0); err != nil {
+		return err
+	}
+	if u.baseOffset > 0 {
+		u.baseOffset += delta
+	}
+	u.prefixLen += delta
+	u.view = nil
+	return nil
+}
+
+// Close finishes updating the zip archive by writing the central directory,
+// and commits the changes if the journal is enabled, see [WithJournal].
+// It does not close the underlying writer.
+//
+// If the zip archive shrinks, it is truncated after the end of central
+// directory record when the underlying writer implements Truncate(int64) error
+// like [os.File]. Otherwise the directory record is moved to the end of the
+// file and the space before it is filled with zeros, see
+// [WithRequireTruncate].
 func (u *Updater) Close() error {
-	if u.last != nil && !u.last.closed {
-		if err := u.last.close(); err != nil {
-			return err
-		}
-		u.last = nil
+	if err := u.closeLast(); err != nil {
+		return err
 	}
+	u.last = nil
 	if u.closed {
 		return errors.New("zip: updater closed twice")
 	}
@@ -492,6 +1238,11 @@ func (u *Updater) Close() error {
 	if err != nil {
 		return err
 	}
+	if _, ok := u.rw.f.(truncater); ok {
+		// The unused space after the file data is truncated instead of
+		// being filled with zeros.
+		u.dirOffset = start
+	}
 	if err = u.writeDirectory(start); err != nil {
 		return fmt.Errorf("zip: write directory: %w", err)
 	}
@@ -504,26 +1255,80 @@ func (u *Updater) Close() error {
 		return err
 	}
 	if fileEndOffset > currentOffset {
+		if err := u.shrink(start, currentOffset, fileEndOffset); err != nil {
+			return err
+		}
+	}
+	if u.seeker != nil {
+		if _, err := u.seeker.Seek(u.rw.size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return u.commit()
+}
+
+// shrink truncates the zip archive after the end of central directory record
+// ending at end. If the file can not be truncated, the directory record
+// written at start is moved to the end of the file instead.
+func (u *Updater) shrink(start, end, fileEnd int64) error {
+	err := u.rw.Truncate(end)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
 		// https://github.com/STARRY-S/zip/issues/3
 		// The directory record is not at the end of the file, re-write the
 		// directory record to ensure that the record is at the end of the file.
-		offset := fileEndOffset - currentOffset
+		offset := fileEnd - end
 		if start < u.dirOffset {
 			u.dirOffset += offset
 		} else {
 			u.dirOffset = start + offset
 		}
-		_, err = u.rw.Seek(start, io.SeekStart)
-		if err != nil {
+		if _, err := u.rw.Seek(start, io.SeekStart); err != nil {
 			return err
 		}
-		if err = u.writeDirectory(start); err != nil {
+		if err := u.writeDirectory(start); err != nil {
 			return fmt.Errorf("zip: write directory: %w", err)
 		}
+	case err != nil:
+		return fmt.Errorf("zip: truncate: %w", err)
 	}
 	return nil
 }
 
+// commit finishes the transaction of the update if the journal is enabled.
+func (u *Updater) commit() error {
+	j := u.rw.journal
+	if j == nil {
+		return nil
+	}
+	if err := u.rw.Sync(); err != nil {
+		return err
+	}
+	u.rw.journal = nil
+	return j.commit()
+}
+
+// Rollback discards all the changes made by the Updater and restores the
+// original content of the zip archive from the journal, see [WithJournal].
+// The Updater can not be used after calling Rollback.
+//
+// Rollback can also be called after [Updater.Close] failed, as long as the
+// changes are not committed.
+func (u *Updater) Rollback() error {
+	j := u.rw.journal
+	if j == nil {
+		return errors.New("zip: rollback without journal")
+	}
+	u.closed = true
+	if u.last != nil {
+		// Discard the file being written.
+		u.last.closed = true
+		u.last = nil
+	}
+	u.rw.journal = nil
+	return j.rollback(u.rw)
+}
+
 func (u *Updater) writeDirectory(start int64) error {
 	var err error
 	if start < u.dirOffset {
@@ -559,7 +1364,18 @@ func (u *Updater) writeDirectory(start int64) error {
 		}
 		start = u.dirOffset
 	}
+	if u.preDir != nil {
+		n, err := io.Copy(u.rw, u.preDir.Open())
+		if err != nil {
+			return err
+		}
+		start += n
+	}
 	for _, h := range u.dir {
+		// The offset of the file header may be changed, the zip64 extra
+		// block is re-generated from the FileHeader.
+		extra := stripExtra(h.Extra, zip64ExtraID)
+		offset := h.offset - uint64(u.baseOffset)
 		var buf []byte = make([]byte, directoryHeaderLen)
 		b := writeBuf(buf)
 		b.uint32(uint32(directoryHeaderSignature))
@@ -570,7 +1386,7 @@ func (u *Updater) writeDirectory(start int64) error {
 		b.uint16(h.ModifiedTime)
 		b.uint16(h.ModifiedDate)
 		b.uint32(h.CRC32)
-		if h.isZip64() || h.offset >= uint32max {
+		if h.isZip64() || offset >= uint32max {
 			// the file needs a zip64 header. store maxint in both
 			// 32 bit size fields (and offset later) to signal that the
 			// zip64 extra header should be used.
@@ -584,22 +1400,22 @@ func (u *Updater) writeDirectory(start int64) error {
 			eb.uint16(24) // size = 3x uint64
 			eb.uint64(h.UncompressedSize64)
 			eb.uint64(h.CompressedSize64)
-			eb.uint64(uint64(h.offset))
-			h.Extra = append(h.Extra, buf[:]...)
+			eb.uint64(offset)
+			extra = append(extra, buf[:]...)
 		} else {
 			b.uint32(h.CompressedSize)
 			b.uint32(h.UncompressedSize)
 		}
 
 		b.uint16(uint16(len(h.Name)))
-		b.uint16(uint16(len(h.Extra)))
+		b.uint16(uint16(len(extra)))
 		b.uint16(uint16(len(h.Comment)))
 		b = b[4:] // skip disk number start and internal file attr (2x uint16)
 		b.uint32(h.ExternalAttrs)
-		if h.offset > uint32max {
+		if offset > uint32max {
 			b.uint32(uint32max)
 		} else {
-			b.uint32(uint32(h.offset))
+			b.uint32(uint32(offset))
 		}
 		if _, err := u.rw.Write(buf); err != nil {
 			return err
@@ -607,7 +1423,7 @@ func (u *Updater) writeDirectory(start int64) error {
 		if _, err := io.WriteString(u.rw, h.Name); err != nil {
 			return err
 		}
-		if _, err := u.rw.Write(h.Extra); err != nil {
+		if _, err := u.rw.Write(extra); err != nil {
 			return err
 		}
 		if _, err := io.WriteString(u.rw, h.Comment); err != nil {
@@ -621,7 +1437,7 @@ func (u *Updater) writeDirectory(start int64) error {
 
 	records := uint64(len(u.dir))
 	size := uint64(end - start)
-	offset := uint64(start)
+	offset := uint64(start - u.baseOffset)
 
 	if records >= uint16max || size >= uint32max || offset >= uint32max {
 		var buf [directory64EndLen + directory64LocLen]byte
@@ -641,9 +1457,9 @@ func (u *Updater) writeDirectory(start int64) error {
 
 		// zip64 end of central directory locator
 		b.uint32(directory64LocSignature)
-		b.uint32(0)           // number of the disk with the start of the zip64 end of central directory
-		b.uint64(uint64(end)) // relative offset of the zip64 end of central directory record
-		b.uint32(1)           // total number of disks
+		b.uint32(0)                          // number of the disk with the start of the zip64 end of central directory
+		b.uint64(uint64(end - u.baseOffset)) // relative offset of the zip64 end of central directory record
+		b.uint32(1)                          // total number of disks
 
 		if _, err := u.rw.Write(buf[:]); err != nil {
 			return err
@@ -675,6 +1491,40 @@ func (u *Updater) writeDirectory(start int64) error {
 	return nil
 }
 
+// stripExtra returns a copy of the extra data without the extra blocks of
+// the given header ID.
+func stripExtra(extra []byte, id uint16) []byte {
+	var out []byte
+	b := readBuf(extra)
+	for len(b) >= 4 { // need at least tag and size
+		field := b
+		tag := b.uint16()
+		size := int(b.uint16())
+		if len(b) < size {
+			break
+		}
+		b.sub(size)
+		if tag != id {
+			out = append(out, field[:4+size]...)
+		}
+	}
+	// Keep the malformed trailing data as is.
+	return append(out, b...)
+}
+
+// extTimeExtra returns the "extended timestamp" extra block of the
+// modification time t.
+func extTimeExtra(t time.Time) []byte {
+	var mbuf [9]byte // 2*SizeOf(uint16) + SizeOf(uint8) + SizeOf(uint32)
+	mt := uint32(t.Unix())
+	eb := writeBuf(mbuf[:])
+	eb.uint16(extTimeExtraID)
+	eb.uint16(5)  // Size: SizeOf(uint8) + SizeOf(uint32)
+	eb.uint8(1)   // Flags: ModTime
+	eb.uint32(mt) // ModTime
+	return mbuf[:]
+}
+
 func sortDirectoryFunc(a, b *header) int {
 	switch {
 	case a.offset > b.offset:
@@ -1,15 +1,22 @@
 package zip
 
 import (
+	"bytes"
+	"compress/flate"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
+	"maps"
 	"math/rand/v2"
 	"os"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -591,3 +598,737 @@ func TestDirectorySizeShrinked(t *testing.T) {
 		rc.Close()
 	}
 }
+
+// createTestZip creates a temporary zip archive with the given files. The
+// archive is removed when the test finishes.
+func createTestZip(t *testing.T, tests []WriteTest) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp("", "test-*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := f.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := os.Remove(f.Name()); err != nil {
+			t.Error(err)
+		}
+	})
+	w := NewWriter(f)
+	for _, wt := range tests {
+		testCreate(t, w, &wt)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// openTestZip opens the zip archive f for validation.
+func openTestZip(t *testing.T, f *os.File) *Reader {
+	t.Helper()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestUpdaterDelete(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Delete("foo", "setgid", "chardevice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := []WriteTest{
+		overwriteTestsOriginal[1],
+		overwriteTestsOriginal[2],
+		overwriteTestsOriginal[3],
+		overwriteTestsOriginal[5],
+		overwriteTestsOriginal[6],
+	}
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+func TestUpdaterDeleteAppend(t *testing.T) {
+	// The new file is written right after the moved files, without leaving
+	// the old end of the data in between.
+	f := createTestZip(t, overwriteTestsOriginal[:3])
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Delete(overwriteTestsOriginal[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	newFile := WriteTest{Name: "new", Data: []byte("new content"), Method: Store, Mode: 0666}
+	testAppend(t, u, &newFile, APPEND_MODE_KEEP_ORIGINAL)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := append(overwriteTestsOriginal[1:3:3], newFile)
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	var end int64
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+		if r.File[i].headerOffset != end {
+			t.Errorf("file %q at offset %d, want %d", wt.Name, r.File[i].headerOffset, end)
+		}
+		if end, err = r.File[i].DataOffset(); err != nil {
+			t.Fatal(err)
+		}
+		end += int64(r.File[i].CompressedSize64)
+		if r.File[i].Flags&0x8 != 0 {
+			end += dataDescriptorLen
+		}
+	}
+}
+
+func TestUpdaterDeleteNotExist(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Delete("foo", "not-exist"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Delete: got error %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	for i, wt := range overwriteTestsOriginal {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+func TestUpdaterDeleteFunc(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := createTestZip(t, nil)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		w, err := u.AppendHeader(&FileHeader{
+			Name:     fmt.Sprintf("%d.txt", i),
+			Method:   Deflate,
+			Modified: modified,
+		}, APPEND_MODE_KEEP_ORIGINAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fmt.Fprintf(w, "file %d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Delete the files with odd number, including the last appended one.
+	n, err := u.DeleteFunc(func(fh *FileHeader) bool {
+		var i int
+		fmt.Sscanf(fh.Name, "%d.txt", &i)
+		return i%2 == 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("DeleteFunc: removed %d files, want 5", n)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	if len(r.File) != 5 {
+		t.Fatalf("got %d files, want 5", len(r.File))
+	}
+	for i, f := range r.File {
+		testReadFile(t, f, &WriteTest{
+			Name: fmt.Sprintf("%d.txt", i*2),
+			Data: []byte(fmt.Sprintf("file %d", i*2)),
+			Mode: 0666,
+		})
+		if !f.Modified.Equal(modified) {
+			t.Errorf("file %q: Modified %v, want %v", f.Name, f.Modified, modified)
+		}
+	}
+}
+
+func TestUpdaterRename(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Rename("foo", "foo-renamed-with-a-longer-name"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Rename("setuid", "s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Rename("bar", "device"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Rename: got error %v, want %v", err, fs.ErrExist)
+	}
+	if err := u.Rename("not-exist", "foo"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Rename: got error %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := u.Rename("bar", "bar"); err != nil {
+		t.Errorf("Rename to the same name: %v", err)
+	}
+	if err := u.Rename("not-exist", "not-exist"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Rename: got error %v, want %v", err, fs.ErrNotExist)
+	}
+	// Append after renaming to ensure the write offset is moved with the data.
+	w, err := u.Append("appended", APPEND_MODE_KEEP_ORIGINAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "appended data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := slices.Clone(overwriteTestsOriginal)
+	want[0].Name = "foo-renamed-with-a-longer-name"
+	want[3].Name = "s"
+	want = append(want, WriteTest{Name: "appended", Data: []byte("appended data"), Mode: 0666})
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+func TestUpdaterUpdateHeader(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	err = u.UpdateHeader("foo2", func(fh *FileHeader) {
+		fh.Comment = "updated comment"
+		fh.Modified = modified
+		fh.SetMode(0600)
+		fh.CRC32 = 0 // should be ignored
+		fh.Method = Deflate
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.UpdateHeader("setgid/", func(fh *FileHeader) {})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("UpdateHeader: got error %v, want %v", err, fs.ErrNotExist)
+	}
+	err = u.UpdateHeader("bar", func(fh *FileHeader) { fh.Name = "bar/" })
+	if err == nil {
+		t.Errorf("UpdateHeader: change file to directory should fail")
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := slices.Clone(overwriteTestsOriginal)
+	want[1].Mode = 0600
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+	got := r.File[1]
+	if got.Comment != "updated comment" {
+		t.Errorf("Comment: got %q, want %q", got.Comment, "updated comment")
+	}
+	if !got.Modified.Equal(modified) {
+		t.Errorf("Modified: got %v, want %v", got.Modified, modified)
+	}
+	if got.Method != Store {
+		t.Errorf("Method: got %v, want %v", got.Method, Store)
+	}
+}
+
+func TestUpdaterCopy(t *testing.T) {
+	largeData := make([]byte, 1<<17)
+	for i := range largeData {
+		largeData[i] = byte(rand.Int32())
+	}
+	srcTests := slices.Clone(overwriteTestsReplaced)
+	srcTests[2].Data = largeData
+	src := openTestZip(t, createTestZip(t, srcTests))
+
+	f := createTestZip(t, overwriteTestsOriginal[:2])
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sf := range src.File {
+		if err := u.Copy(sf, APPEND_MODE_OVERWRITE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	if len(r.File) != len(srcTests) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(srcTests))
+	}
+	for i, wt := range srcTests {
+		testReadFile(t, r.File[i], &wt)
+
+		// The raw data should be copied as is.
+		want, err := src.File[i].OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.File[i].OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantRaw, _ := io.ReadAll(want)
+		gotRaw, _ := io.ReadAll(got)
+		if !bytes.Equal(gotRaw, wantRaw) {
+			t.Errorf("file %q: raw data mismatch", wt.Name)
+		}
+	}
+}
+
+func TestUpdaterAppendRaw(t *testing.T) {
+	data := []byte(strings.Repeat("raw deflate data ", 100))
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	fw.Close()
+
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := &FileHeader{
+		Name:               "foo",
+		Method:             Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(data)),
+	}
+	fh.SetMode(0644)
+	w, err := u.AppendRaw(fh, APPEND_MODE_OVERWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(compressed.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.AppendRaw(&FileHeader{Name: "dir/"}, APPEND_MODE_KEEP_ORIGINAL); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	want := append(slices.Clone(overwriteTestsOriginal[1:]),
+		WriteTest{Name: "foo", Data: data, Mode: 0644},
+		WriteTest{Name: "dir/", Mode: fs.ModeDir | 0666},
+	)
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+	if r.File[len(want)-2].hasDataDescriptor() {
+		t.Errorf("AppendRaw: unexpected data descriptor")
+	}
+}
+
+func TestUpdaterRegisterCompressor(t *testing.T) {
+	const customMethod = 0xffee
+	var called int
+	// The custom method inverts the bits of every byte.
+	invert := func(b []byte) {
+		for i := range b {
+			b[i] = ^b[i]
+		}
+	}
+	comp := func(w io.Writer) (io.WriteCloser, error) {
+		called++
+		return &nopCloser{writerFunc(func(p []byte) (int, error) {
+			b := slices.Clone(p)
+			invert(b)
+			return w.Write(b)
+		})}, nil
+	}
+
+	f := createTestZip(t, overwriteTestsOriginal[:1])
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.RegisterCompressor(customMethod, comp)
+	// Override the package level Deflate compressor.
+	u.RegisterCompressor(Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestCompression)
+	})
+	want := []WriteTest{
+		overwriteTestsOriginal[0],
+		{Name: "custom", Data: []byte("custom method data"), Method: customMethod, Mode: 0644},
+		{Name: "deflate", Data: []byte(strings.Repeat("deflate", 100)), Method: Deflate, Mode: 0644},
+	}
+	for _, wt := range want[1:] {
+		testAppend(t, u, &wt, APPEND_MODE_KEEP_ORIGINAL)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if called != 1 {
+		t.Errorf("custom compressor called %d times, want 1", called)
+	}
+
+	// Other updaters are not affected.
+	u, err = NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.AppendHeader(&FileHeader{Name: "x", Method: customMethod}, APPEND_MODE_KEEP_ORIGINAL); err != ErrAlgorithm {
+		t.Errorf("AppendHeader: got error %v, want %v", err, ErrAlgorithm)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openTestZip(t, f)
+	r.RegisterDecompressor(customMethod, func(r io.Reader) io.ReadCloser {
+		return io.NopCloser(readerFunc(func(p []byte) (int, error) {
+			n, err := r.Read(p)
+			invert(p[:n])
+			return n, err
+		}))
+	})
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestUpdaterFiles(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(u.Files()); got != len(overwriteTestsOriginal) {
+		t.Fatalf("Files: got %d files, want %d", got, len(overwriteTestsOriginal))
+	}
+	// Overwrite, delete, rename and append files.
+	testAppend(t, u, &overwriteTestsReplaced[0], APPEND_MODE_OVERWRITE)
+	if err := u.Delete("setuid"); err != nil {
+		t.Fatal(err)
+	}
+	appended := WriteTest{Name: "dir/appended", Data: []byte("appended"), Mode: 0644}
+	testAppend(t, u, &appended, APPEND_MODE_KEEP_ORIGINAL)
+	if err := u.Rename("device", "dir/device"); err != nil {
+		t.Fatal(err)
+	}
+	testAppend(t, u, &WriteTest{Name: "unfinished", Data: []byte("unfinished")}, APPEND_MODE_KEEP_ORIGINAL)
+
+	want := []WriteTest{
+		overwriteTestsOriginal[1],
+		overwriteTestsOriginal[2],
+		overwriteTestsOriginal[4],
+		overwriteTestsOriginal[5],
+		overwriteTestsOriginal[6],
+		overwriteTestsOriginal[7],
+		overwriteTestsReplaced[0],
+		appended,
+	}
+	want[4].Name = "dir/device"
+	// The last appended file is not finished yet.
+	files := u.Files()
+	if len(files) != len(want) {
+		t.Fatalf("Files: got %d files, want %d", len(files), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, files[i], &wt)
+	}
+
+	// The Updater implements fs.FS.
+	b, err := fs.ReadFile(u, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, overwriteTestsReplaced[0].Data) {
+		t.Errorf("ReadFile: got %q, want %q", b, overwriteTestsReplaced[0].Data)
+	}
+	if err := fstest.TestFS(u, "foo", "foo2", "dir/device", "dir/appended"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"setuid", "device", "unfinished"} {
+		if _, err := u.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open %q: got error %v, want %v", name, err, fs.ErrNotExist)
+		}
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewUpdaterAt(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The offset of f should not be changed by the Updater.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUpdaterAt(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wt := range overwriteTestsReplaced[:2] {
+		testAppend(t, u, &wt, APPEND_MODE_OVERWRITE)
+	}
+	if err := u.Delete("setuid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Compact(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := f.Seek(0, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	} else if offset != 0 {
+		t.Errorf("got file offset %d, want 0", offset)
+	}
+
+	r := openTestZip(t, f)
+	want := []WriteTest{
+		overwriteTestsOriginal[2],
+		overwriteTestsOriginal[4],
+		overwriteTestsOriginal[5],
+		overwriteTestsOriginal[6],
+		overwriteTestsOriginal[7],
+		overwriteTestsReplaced[0],
+		overwriteTestsReplaced[1],
+	}
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for i, wt := range want {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+func TestUpdaterTruncate(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The size is not changed if nothing is updated.
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	} else if got != size {
+		t.Errorf("got size %d after Close, want %d", got, size)
+	}
+
+	u, err = NewUpdater(f, WithRequireTruncate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAppend(t, u, &overwriteTestsReplaced[1], APPEND_MODE_OVERWRITE)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The replaced data of foo2 is shorter than the original data.
+	want := size - int64(len(overwriteTestsOriginal[1].Data)-len(overwriteTestsReplaced[1].Data))
+	if got, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	} else if got != want {
+		t.Errorf("got size %d after Close, want %d", got, want)
+	}
+
+	r := openTestZip(t, f)
+	tests := slices.Delete(slices.Clone(overwriteTestsOriginal), 1, 2)
+	tests = append(tests, overwriteTestsReplaced[1])
+	if len(r.File) != len(tests) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(tests))
+	}
+	for i, wt := range tests {
+		testReadFile(t, r.File[i], &wt)
+	}
+}
+
+func TestUpdaterRequireTruncate(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	// Hide the Truncate method of f.
+	rws := struct{ io.ReadWriteSeeker }{f}
+	if _, err := NewUpdater(rws, WithRequireTruncate()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("got error %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// copyTestdata returns a temporary copy of the zip archive in testdata.
+func copyTestdata(t *testing.T, name string) *os.File {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := createTestZip(t, nil)
+	if err := f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// readTestFiles returns the contents of the files in the zip archive.
+func readTestFiles(t *testing.T, r *Reader) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("file %q: %v", f.Name, err)
+		}
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestUpdaterPrefix(t *testing.T) {
+	f := copyTestdata(t, "test-prefix.zip")
+	original := readTestFileData(t, f)
+	want := readTestFiles(t, openTestZip(t, f))
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Delete("test.txt"); err != nil {
+		t.Fatal(err)
+	}
+	testAppend(t, u, &overwriteTestsOriginal[0], APPEND_MODE_OVERWRITE)
+	if _, err := u.Compact(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "test.txt")
+	want[overwriteTestsOriginal[0].Name] = string(overwriteTestsOriginal[0].Data)
+
+	const prefixLen = 43
+	if got := readTestFileData(t, f); !bytes.Equal(got[:prefixLen], original[:prefixLen]) {
+		t.Errorf("got prefix %q, want %q", got[:prefixLen], original[:prefixLen])
+	}
+	r := openTestZip(t, f)
+	if r.baseOffset != prefixLen {
+		t.Errorf("got base offset %d, want %d", r.baseOffset, prefixLen)
+	}
+	if got := readTestFiles(t, r); !maps.Equal(got, want) {
+		t.Errorf("got files %q, want %q", slices.Collect(maps.Keys(got)), slices.Collect(maps.Keys(want)))
+	}
+}
+
+func TestUpdaterSetPrefix(t *testing.T) {
+	prefix := "#!/bin/sh\nexec java -jar \"$0\" \"$@\"\n"
+	for _, relative := range []bool{true, false} {
+		t.Run(fmt.Sprintf("relative=%v", relative), func(t *testing.T) {
+			var f *os.File
+			if relative {
+				f = copyTestdata(t, "test-prefix.zip")
+			} else {
+				f = createTestZip(t, overwriteTestsOriginal)
+			}
+			want := readTestFiles(t, openTestZip(t, f))
+			for _, prefix := range []string{prefix, prefix[:10], ""} {
+				u, err := NewUpdater(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := u.SetPrefix(strings.NewReader(prefix)); err != nil {
+					t.Fatal(err)
+				}
+				if err := u.Close(); err != nil {
+					t.Fatal(err)
+				}
+				data := readTestFileData(t, f)
+				if got := string(data[:len(prefix)]); got != prefix {
+					t.Errorf("got prefix %q, want %q", got, prefix)
+				}
+				if sig := binary.LittleEndian.Uint32(data[len(prefix):]); sig != fileHeaderSignature {
+					t.Errorf("got signature %#x after prefix, want %#x", sig, fileHeaderSignature)
+				}
+				r := openTestZip(t, f)
+				var base int64
+				if relative {
+					base = int64(len(prefix))
+				}
+				if r.baseOffset != base {
+					t.Errorf("got base offset %d, want %d", r.baseOffset, base)
+				}
+				if got := readTestFiles(t, r); !maps.Equal(got, want) {
+					t.Errorf("prefix %q: files are changed", prefix)
+				}
+			}
+		})
+	}
+}
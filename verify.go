@@ -0,0 +1,195 @@
+package zip
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// VerifyOptions configures [Reader.Verify].
+type VerifyOptions struct {
+	// FailFast stops the verification at the first failure.
+	FailFast bool
+}
+
+// VerifyReport is the result of [Reader.Verify].
+type VerifyReport struct {
+	// Files are the results of the files verified, in the order of
+	// [Reader.File].
+	Files []VerifyResult
+
+	// LeadingJunk is the size of the data before the first local file
+	// header, such as the stub of a self-extracting archive, and TrailingJunk
+	// is the size of the data after the end of central directory record.
+	LeadingJunk  int64
+	TrailingJunk int64
+}
+
+// VerifyResult is the result of verifying a file.
+type VerifyResult struct {
+	File *File
+	Err  error // nil if the file is valid
+}
+
+// Verify tests the integrity of the archive like `unzip -t`, without
+// extracting the files. It checks that
+//
+//   - the local file header of each file is within the archive before the
+//     central directory, and has the same name, method, flags, sizes and
+//     CRC-32 checksum as the directory record, except the sizes and the
+//     checksum stored in the data descriptor;
+//   - the files, from their local file headers to the end of their data
+//     descriptors, do not overlap, see [OverlapError];
+//   - the file names are unique;
+//   - the content of each file is decompressed to the sizes and checksum of
+//     the directory record, using the password function set by
+//     [Reader.SetPasswordFunc] for the encrypted files;
+//   - there is no junk data before the first file or after the end of
+//     central directory record.
+//
+// The report has the result of each file verified, and the sizes of the junk
+// data. The returned error joins all the failures, and is nil if the archive
+// is valid. If opts.FailFast is set, Verify stops at the first failure and
+// the report has the results up to the failed file.
+//
+// The readers returned by [NewReaderRecover] do not know where the central
+// directory is. For them, Verify only checks the files, which are bounded by
+// the end of the data instead of the central directory, and reports no junk.
+func (r *Reader) Verify(opts VerifyOptions) (*VerifyReport, error) {
+	rep := &VerifyReport{
+		Files: make([]VerifyResult, 0, len(r.File)),
+	}
+	dataEnd := r.dirOffset
+	if r.size > 0 {
+		end, _, err := readDirectoryEnd(r.r, r.size)
+		if err != nil {
+			return nil, err
+		}
+		rep.LeadingJunk = r.dirOffset
+		rep.TrailingJunk = r.size - end.endOffset
+	} else {
+		dataEnd = math.MaxInt64
+	}
+
+	// Check the structure first, which needs the ranges of all the files to
+	// detect the overlaps.
+	ferrs := make([]error, len(r.File))
+	type fileRange struct {
+		i          int
+		start, end int64
+	}
+	ranges := make([]fileRange, 0, len(r.File))
+	for i, f := range r.File {
+		rep.LeadingJunk = max(min(rep.LeadingJunk, f.headerOffset), 0)
+		end, err := r.verifyLocalHeader(f, dataEnd)
+		if err != nil {
+			ferrs[i] = err
+			continue
+		}
+		ranges = append(ranges, fileRange{i, f.headerOffset, end})
+	}
+	slices.SortStableFunc(ranges, func(a, b fileRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	for i := 1; i < len(ranges); i++ {
+		if prev := ranges[i-1]; ranges[i].start < prev.end {
+			ferrs[ranges[i].i] = &OverlapError{Name: r.File[ranges[i].i].Name, Other: r.File[prev.i].Name}
+			// Keep the range reaching further for the next files.
+			ranges[i].end = max(ranges[i].end, prev.end)
+		}
+	}
+	names := make(map[string]bool, len(r.File))
+	for i, f := range r.File {
+		if names[f.Name] && ferrs[i] == nil {
+			ferrs[i] = fmt.Errorf("zip: duplicate file name %q", f.Name)
+		}
+		names[f.Name] = true
+	}
+	var errs []error
+	if rep.LeadingJunk > 0 {
+		errs = append(errs, fmt.Errorf("zip: %d bytes of junk before the archive", rep.LeadingJunk))
+	}
+	if rep.TrailingJunk > 0 {
+		errs = append(errs, fmt.Errorf("zip: %d bytes of junk after the archive", rep.TrailingJunk))
+	}
+	if len(errs) > 0 && opts.FailFast {
+		return rep, errors.Join(errs...)
+	}
+
+	for i, f := range r.File {
+		err := ferrs[i]
+		if err == nil {
+			err = verifyContent(f)
+		}
+		rep.Files = append(rep.Files, VerifyResult{File: f, Err: err})
+		if err != nil {
+			errs = append(errs, err)
+			if opts.FailFast {
+				break
+			}
+		}
+	}
+	return rep, errors.Join(errs...)
+}
+
+// verifyLocalHeader compares the local file header of f with its directory
+// record, and returns the end offset of the file data and the data
+// descriptor, which must not be beyond dataEnd.
+func (r *Reader) verifyLocalHeader(f *File, dataEnd int64) (int64, error) {
+	if f.headerOffset < 0 || f.headerOffset >= dataEnd {
+		return 0, fmt.Errorf("zip: file %q is out of bounds: %w", f.Name, ErrFormat)
+	}
+	lf := &File{}
+	if err := readLocalFileHeader(lf, io.NewSectionReader(r.r, f.headerOffset, dataEnd-f.headerOffset)); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("zip: file %q is out of bounds: %w", f.Name, ErrFormat)
+		}
+		return 0, fmt.Errorf("zip: file %q: local file header: %w", f.Name, err)
+	}
+	var field string
+	switch {
+	case lf.Name != f.Name:
+		field = "name"
+	case lf.Method != f.Method:
+		field = "method"
+	case lf.Flags != f.Flags:
+		field = "flags"
+	case lf.hasDataDescriptor():
+		// The sizes and the checksum are in the data descriptor.
+	case lf.CompressedSize64 != f.CompressedSize64 || lf.UncompressedSize64 != f.UncompressedSize64:
+		field = "sizes"
+	case lf.CRC32 != f.CRC32:
+		field = "CRC-32 checksum"
+	}
+	if field != "" {
+		return 0, fmt.Errorf("zip: file %q: local file header has a different %s: %w", f.Name, field, ErrFormat)
+	}
+	if f.CompressedSize64 > uint64(dataEnd) {
+		return 0, fmt.Errorf("zip: file %q is out of bounds: %w", f.Name, ErrFormat)
+	}
+	end, err := fileDataEnd(r.r, &f.FileHeader, f.headerOffset)
+	if err != nil {
+		return 0, fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	if end > dataEnd {
+		return 0, fmt.Errorf("zip: file %q is out of bounds: %w", f.Name, ErrFormat)
+	}
+	return end, nil
+}
+
+// verifyContent decompresses the content of f to check its sizes and CRC-32
+// checksum.
+func verifyContent(f *File) error {
+	rc, err := f.Open()
+	if err == nil {
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("zip: file %q: %w", f.Name, err)
+	}
+	return nil
+}
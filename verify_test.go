@@ -0,0 +1,182 @@
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// verifyTestZip returns an archive of the stored files with the names, and
+// the offsets of their directory records.
+func verifyTestZip(t *testing.T, names ...string) ([]byte, []int) {
+	t.Helper()
+	tests := make([]WriteTest, len(names))
+	for i, name := range names {
+		tests[i] = WriteTest{Name: name, Data: bytes.Repeat([]byte(name), 100), Method: Store}
+	}
+	data := readTestFileData(t, createTestZip(t, tests))
+	var records []int
+	off := int(binary.LittleEndian.Uint32(data[len(data)-6:]))
+	for range names {
+		records = append(records, off)
+		b := data[off:]
+		off += directoryHeaderLen + int(binary.LittleEndian.Uint16(b[28:])) +
+			int(binary.LittleEndian.Uint16(b[30:])) + int(binary.LittleEndian.Uint16(b[32:]))
+	}
+	return data, records
+}
+
+func TestVerify(t *testing.T) {
+	isFormat := func(err error) bool { return errors.Is(err, ErrFormat) }
+	isOverlap := func(err error) bool {
+		var oerr *OverlapError
+		return errors.As(err, &oerr)
+	}
+	isChecksum := func(err error) bool { return errors.Is(err, ErrChecksum) }
+	isDuplicate := func(err error) bool { return err != nil && strings.Contains(err.Error(), "duplicate") }
+
+	tests := []struct {
+		name     string
+		names    []string
+		corrupt  func(b []byte, records []int) []byte
+		want     []func(error) bool // nil for the valid files
+		leading  int64
+		trailing int64
+	}{
+		{
+			name:  "valid",
+			names: []string{"a", "b", "c"},
+			want:  []func(error) bool{nil, nil, nil},
+		},
+		{
+			name:  "checksum",
+			names: []string{"a", "b", "c"},
+			corrupt: func(b []byte, records []int) []byte {
+				b[bytes.Index(b, []byte("bbbb"))+2] = 'x'
+				return b
+			},
+			want: []func(error) bool{nil, isChecksum, nil},
+		},
+		{
+			name:  "local name",
+			names: []string{"a", "b"},
+			corrupt: func(b []byte, records []int) []byte {
+				b[fileHeaderLen] = 'x'
+				return b
+			},
+			want: []func(error) bool{isFormat, nil},
+		},
+		{
+			name:  "local method",
+			names: []string{"a", "b"},
+			corrupt: func(b []byte, records []int) []byte {
+				binary.LittleEndian.PutUint16(b[8:], Deflate)
+				return b
+			},
+			want: []func(error) bool{isFormat, nil},
+		},
+		{
+			name:  "out of bounds",
+			names: []string{"a", "b"},
+			corrupt: func(b []byte, records []int) []byte {
+				binary.LittleEndian.PutUint32(b[records[1]+42:], uint32(records[0]+10))
+				return b
+			},
+			want: []func(error) bool{nil, isFormat},
+		},
+		{
+			name:  "overlap",
+			names: []string{"a", "a"},
+			corrupt: func(b []byte, records []int) []byte {
+				binary.LittleEndian.PutUint32(b[records[1]+42:], 0)
+				return b
+			},
+			want: []func(error) bool{nil, isOverlap},
+		},
+		{
+			name:  "duplicate",
+			names: []string{"a", "b", "a"},
+			want:  []func(error) bool{nil, nil, isDuplicate},
+		},
+		{
+			name:  "junk",
+			names: []string{"a"},
+			corrupt: func(b []byte, records []int) []byte {
+				b = append([]byte("leading"), b...)
+				return append(b, "trailing"...)
+			},
+			want:     []func(error) bool{nil},
+			leading:  7,
+			trailing: 8,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, records := verifyTestZip(t, test.names...)
+			if test.corrupt != nil {
+				data = test.corrupt(data, records)
+			}
+			r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep, err := r.Verify(VerifyOptions{})
+			valid := test.leading == 0 && test.trailing == 0
+			for _, want := range test.want {
+				valid = valid && want == nil
+			}
+			if valid != (err == nil) {
+				t.Errorf("got error %v", err)
+			}
+			if rep.LeadingJunk != test.leading || rep.TrailingJunk != test.trailing {
+				t.Errorf("got junk %d and %d, want %d and %d", rep.LeadingJunk, rep.TrailingJunk, test.leading, test.trailing)
+			}
+			if len(rep.Files) != len(test.want) {
+				t.Fatalf("got %d results, want %d", len(rep.Files), len(test.want))
+			}
+			for i, res := range rep.Files {
+				if res.File != r.File[i] {
+					t.Errorf("result %d: got file %q, want %q", i, res.File.Name, r.File[i].Name)
+				}
+				if want := test.want[i]; want == nil && res.Err != nil || want != nil && !want(res.Err) {
+					t.Errorf("file %d %q: unexpected error %v", i, res.File.Name, res.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyFailFast(t *testing.T) {
+	data, _ := verifyTestZip(t, "a", "b", "c")
+	data[bytes.Index(data, []byte("bbbb"))+2] = 'x'
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := r.Verify(VerifyOptions{FailFast: true})
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrChecksum)
+	}
+	if len(rep.Files) != 2 || rep.Files[0].Err != nil || rep.Files[1].Err == nil {
+		t.Errorf("got %d results, want the valid file and the failed one", len(rep.Files))
+	}
+}
+
+func TestVerifyRecover(t *testing.T) {
+	// The reader of NewReaderRecover does not know the central directory.
+	data, _ := verifyTestZip(t, "a", "b", "c")
+	data = append([]byte("leading"), data...)
+	r, err := NewReaderRecover(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := r.Verify(VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Files) != 3 || rep.LeadingJunk != 0 || rep.TrailingJunk != 0 {
+		t.Errorf("got %d results and junk %d and %d, want 3 results without junk", len(rep.Files), rep.LeadingJunk, rep.TrailingJunk)
+	}
+}
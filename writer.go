@@ -301,7 +301,10 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 	}
 
 	fh.CreatorVersion = fh.CreatorVersion&0xff00 | zipVersion20 // preserve compatibility byte
-	fh.ReaderVersion = zipVersion20
+	fh.ReaderVersion = readerVersion(fh.Method)
+	if fh.Method == LZMA && w.compressors[LZMA] == nil && isBuiltinCompressor(LZMA) {
+		fh.Flags |= lzmaEOSFlag // the built-in compressor writes the end of stream marker
+	}
 
 	// If Modified is set, this takes precedence over MS-DOS timestamp fields.
 	if !fh.Modified.IsZero() {
@@ -332,8 +335,9 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 	}
 
 	var (
-		ow io.Writer
-		fw *fileWriter
+		ow   io.Writer
+		fw   *fileWriter
+		comp Compressor
 	)
 	h := &header{
 		FileHeader: fh,
@@ -363,16 +367,15 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 			compCount: &countWriter{w: w.cw},
 			crc32:     crc32.NewIEEE(),
 		}
-		comp := w.compressor(fh.Method)
+		comp = w.compressor(fh.Method)
 		if comp == nil {
 			return nil, ErrAlgorithm
 		}
 		var err error
-		fw.comp, err = comp(fw.compCount)
+		comp, err = encryptCompressor(fh, comp)
 		if err != nil {
 			return nil, err
 		}
-		fw.rawCount = &countWriter{w: fw.comp}
 		fw.header = h
 		ow = fw
 	}
@@ -380,6 +383,15 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 	if err := writeHeader(w.cw, h); err != nil {
 		return nil, err
 	}
+	if fw != nil {
+		// The compressor is created after the local file header is written,
+		// since it may write the header of the stream right away.
+		var err error
+		if fw.comp, err = comp(fw.compCount); err != nil {
+			return nil, err
+		}
+		fw.rawCount = &countWriter{w: fw.comp}
+	}
 	// If we're creating a directory, fw is nil.
 	w.last = fw
 	return ow, nil
@@ -594,13 +606,17 @@ func (w *fileWriter) close() error {
 	// update FileHeader
 	fh := w.header.FileHeader
 	fh.CRC32 = w.crc32.Sum32()
+	if fh.Method == winZipAES {
+		// The CRC-32 checksum is not stored in the AE-2 format.
+		fh.CRC32 = 0
+	}
 	fh.CompressedSize64 = uint64(w.compCount.count)
 	fh.UncompressedSize64 = uint64(w.rawCount.count)
 
 	if fh.isZip64() {
 		fh.CompressedSize = uint32max
 		fh.UncompressedSize = uint32max
-		fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+		fh.ReaderVersion = max(fh.ReaderVersion, zipVersion45) // requires 4.5 - File uses ZIP64 format extensions
 	} else {
 		fh.CompressedSize = uint32(fh.CompressedSize64)
 		fh.UncompressedSize = uint32(fh.UncompressedSize64)
@@ -17,16 +17,18 @@ import (
 	"strings"
 	"testing"
 	"testing/fstest"
+	"testing/iotest"
 	"time"
 )
 
 // TODO(adg): a more sophisticated test suite
 
 type WriteTest struct {
-	Name   string
-	Data   []byte
-	Method uint16
-	Mode   fs.FileMode
+	Name     string
+	Data     []byte
+	Method   uint16
+	Mode     fs.FileMode
+	Modified time.Time
 }
 
 var writeTests = []WriteTest{
@@ -368,6 +370,55 @@ func TestWriterDirAttributes(t *testing.T) {
 	}
 }
 
+func TestWriterCompressorHeader(t *testing.T) {
+	// The compressor writes the header of its stream when it is created,
+	// which must follow the local file header.
+	const customMethod = 0xffee
+	const streamHeader = "stream header"
+	comp := func(w io.Writer) (io.WriteCloser, error) {
+		if _, err := io.WriteString(w, streamHeader); err != nil {
+			return nil, err
+		}
+		return &nopCloser{w}, nil
+	}
+	dcomp := func(r io.Reader) io.ReadCloser {
+		b := make([]byte, len(streamHeader))
+		if _, err := io.ReadFull(r, b); err != nil || string(b) != streamHeader {
+			return io.NopCloser(iotest.ErrReader(ErrFormat))
+		}
+		return io.NopCloser(r)
+	}
+	wt := WriteTest{Name: "custom", Data: []byte("custom method data"), Method: customMethod, Mode: 0644}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.RegisterCompressor(customMethod, comp)
+	testCreate(t, w, &wt)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterDecompressor(customMethod, dcomp)
+	testReadFile(t, r.File[0], &wt)
+
+	f := createTestZip(t, nil)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.RegisterCompressor(customMethod, comp)
+	testAppend(t, u, &wt, APPEND_MODE_KEEP_ORIGINAL)
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r = openTestZip(t, f)
+	r.RegisterDecompressor(customMethod, dcomp)
+	testReadFile(t, r.File[0], &wt)
+}
+
 func TestWriterCopy(t *testing.T) {
 	// make a zip file
 	buf := new(bytes.Buffer)
@@ -535,8 +586,9 @@ func TestWriterCreateRaw(t *testing.T) {
 
 func testCreate(t *testing.T, w *Writer, wt *WriteTest) {
 	header := &FileHeader{
-		Name:   wt.Name,
-		Method: wt.Method,
+		Name:     wt.Name,
+		Method:   wt.Method,
+		Modified: wt.Modified,
 	}
 	if wt.Mode != 0 {
 		header.SetMode(wt.Mode)
@@ -0,0 +1,146 @@
+package zip
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"io"
+)
+
+// InsecureZipCrypto is the traditional PKWARE encryption (ZipCrypto), which
+// is broken and must only be used for the compatibility with legacy tools
+// which do not support the WinZip AES encryption, see
+// [FileHeader.SetPassword].
+const InsecureZipCrypto EncryptionMethod = 4
+
+// zipCryptoHeaderLen is the length of the encryption header before the
+// encrypted file data.
+const zipCryptoHeaderLen = 12
+
+// zipCrypto is the stream cipher of the traditional PKWARE encryption, see
+// section 6.1 of the ZIP specification.
+type zipCrypto struct {
+	keys [3]uint32
+}
+
+func newZipCrypto(password string) *zipCrypto {
+	z := &zipCrypto{keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		z.update(password[i])
+	}
+	return z
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.keys[0] = crc32Update(z.keys[0], b)
+	z.keys[1] = (z.keys[1]+z.keys[0]&0xff)*134775813 + 1
+	z.keys[2] = crc32Update(z.keys[2], byte(z.keys[1]>>24))
+}
+
+func (z *zipCrypto) stream() byte {
+	t := z.keys[2] | 2
+	return byte((t * (t ^ 1)) >> 8)
+}
+
+func (z *zipCrypto) decrypt(p []byte) {
+	for i, c := range p {
+		p[i] = c ^ z.stream()
+		z.update(p[i])
+	}
+}
+
+func (z *zipCrypto) encrypt(dst, src []byte) {
+	for i, c := range src {
+		t := z.stream()
+		z.update(c)
+		dst[i] = c ^ t
+	}
+}
+
+// zipCryptoCheck returns the last byte of the encryption header, which is
+// used to check the password. It is the high byte of the MS-DOS time if the
+// sizes and the CRC-32 checksum are stored in the data descriptor, otherwise
+// it is the high byte of the CRC-32 checksum.
+func (h *FileHeader) zipCryptoCheck() byte {
+	if h.hasDataDescriptor() {
+		return byte(h.ModifiedTime >> 8)
+	}
+	return byte(h.CRC32 >> 24)
+}
+
+// zipCryptoReader decrypts the file data encrypted by the traditional PKWARE
+// encryption.
+type zipCryptoReader struct {
+	r io.Reader
+	z *zipCrypto
+}
+
+// newZipCryptoReader returns a reader of the decrypted content of the file
+// data r. It returns ErrPassword if the last byte of the decrypted encryption
+// header is not check.
+func newZipCryptoReader(r io.Reader, password string, check byte) (io.Reader, error) {
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	z := newZipCrypto(password)
+	z.decrypt(header[:])
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, ErrPassword
+	}
+	return &zipCryptoReader{r: r, z: z}, nil
+}
+
+func (r *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.z.decrypt(p[:n])
+	return n, err
+}
+
+// zipCryptoWriter encrypts the data written to w. The encryption header is
+// written before the data.
+type zipCryptoWriter struct {
+	w   io.Writer
+	z   *zipCrypto
+	buf []byte
+}
+
+// newZipCryptoWriter returns a writer encrypting the data written to w, and
+// writes the encryption header ending with check.
+func newZipCryptoWriter(w io.Writer, password string, check byte) (*zipCryptoWriter, error) {
+	var header [zipCryptoHeaderLen]byte
+	if _, err := rand.Read(header[:zipCryptoHeaderLen-1]); err != nil {
+		return nil, err
+	}
+	header[zipCryptoHeaderLen-1] = check
+	z := newZipCrypto(password)
+	z.encrypt(header[:], header[:])
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return &zipCryptoWriter{w: w, z: z, buf: make([]byte, 4096)}, nil
+}
+
+func (w *zipCryptoWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := min(len(p), len(w.buf))
+		w.z.encrypt(w.buf[:n], p[:n])
+		if _, err := w.w.Write(w.buf[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *zipCryptoWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,139 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderZipCrypto(t *testing.T) {
+	// Created by Info-ZIP zip -P go-zip, which stores the sizes and the CRC-32
+	// checksum in the data descriptor.
+	f, err := os.Open("testdata/zipcrypto.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"short.txt": "short\n",
+		"long.txt":  strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40) + "\n",
+	}
+	if len(r.File) != len(want) {
+		t.Fatalf("got %d files, want %d", len(r.File), len(want))
+	}
+	for _, zf := range r.File {
+		if !zf.IsEncrypted() {
+			t.Errorf("file %q is not encrypted", zf.Name)
+		}
+		if _, err := zf.Open(); !errors.Is(err, ErrPassword) {
+			t.Errorf("file %q: Open without password: got error %v, want %v", zf.Name, err, ErrPassword)
+		}
+		if _, err := zf.OpenWithPassword("wrong"); !errors.Is(err, ErrPassword) {
+			t.Errorf("file %q: got error %v, want %v", zf.Name, err, ErrPassword)
+		}
+		testOpenWithPassword(t, zf, "go-zip", want[zf.Name])
+	}
+}
+
+func TestReaderZipCryptoCRC(t *testing.T) {
+	// Without the data descriptor, the password is checked by the CRC-32
+	// checksum.
+	content := []byte("checked by the CRC-32 checksum")
+	fh := &FileHeader{
+		Name:               "file",
+		Method:             Store,
+		Flags:              0x1,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(zipCryptoHeaderLen + len(content)),
+		UncompressedSize64: uint64(len(content)),
+	}
+	data := make([]byte, zipCryptoHeaderLen, zipCryptoHeaderLen+len(content))
+	data[zipCryptoHeaderLen-1] = byte(fh.CRC32 >> 24)
+	data = append(data, content...)
+	newZipCrypto("password").encrypt(data, data)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	fw, err := w.CreateRaw(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOpenWithPassword(t, r.File[0], "password", string(content))
+}
+
+func TestWriterZipCrypto(t *testing.T) {
+	data := strings.Repeat("encrypted data ", 100)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, method := range []uint16{Store, Deflate} {
+		fh := &FileHeader{Name: "file", Method: method}
+		fh.SetPassword("password", InsecureZipCrypto)
+		fh.SetModTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(fw, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range r.File {
+		if !f.IsEncrypted() {
+			t.Errorf("file %d is not encrypted", i)
+		}
+		testOpenWithPassword(t, f, "password", data)
+	}
+}
+
+func TestUpdaterZipCrypto(t *testing.T) {
+	f := createTestZip(t, overwriteTestsOriginal)
+	u, err := NewUpdater(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := &FileHeader{Name: "foo", Method: Deflate}
+	fh.SetPassword("password", InsecureZipCrypto)
+	w, err := u.AppendHeader(fh, APPEND_MODE_OVERWRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "secret data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openTestZip(t, f)
+	testOpenWithPassword(t, testFileByName(t, r, "foo"), "password", "secret data")
+}